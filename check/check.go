@@ -0,0 +1,106 @@
+// Package check finds likely mistakes in Quinn code without running it.
+package check
+
+import (
+	"fmt"
+
+	"github.com/erikfastermann/quinn/parser"
+)
+
+type Warning struct {
+	Path         string
+	Line, Column int
+	Message      string
+}
+
+func warnf(p parser.Positioned, format string, v ...interface{}) Warning {
+	path, line, col := p.Position()
+	return Warning{path, line, col, fmt.Sprintf(format, v...)}
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s|%d col %d| warning: %s", w.Path, w.Line, w.Column, w.Message)
+}
+
+func Check(b parser.Block) []Warning {
+	var c checker
+	c.element(b)
+	return c.warnings
+}
+
+type checker struct {
+	warnings []Warning
+}
+
+func (c *checker) element(e parser.Element) {
+	switch e := e.(type) {
+	case parser.Block:
+		for _, e := range e.V {
+			c.element(e)
+		}
+	case parser.Call:
+		if ref, ok := e.First.(parser.Ref); ok && ref.V == "match" && len(e.Args) == 2 {
+			if arms, ok := e.Args[1].(parser.List); ok {
+				c.match(arms)
+			}
+		}
+		c.element(e.First)
+		for _, arg := range e.Args {
+			c.element(arg)
+		}
+	case parser.List:
+		for _, e := range e.V {
+			c.element(e)
+		}
+	case parser.Map:
+		for _, e := range e.V {
+			c.element(e)
+		}
+	}
+}
+
+// match warns about arms of a match literal which can never be reached.
+func (c *checker) match(arms parser.List) {
+	if len(arms.V)%2 != 0 {
+		c.warnings = append(c.warnings, warnf(
+			arms,
+			"match expects pattern and block pairs, got %d elements",
+			len(arms.V),
+		))
+	}
+
+	matchesAll := false
+	literals := make(map[string]bool)
+	for i := 0; i < len(arms.V); i += 2 {
+		pattern := arms.V[i]
+		if matchesAll {
+			c.warnings = append(c.warnings, warnf(
+				pattern,
+				"unreachable match arm, an earlier pattern matches every value",
+			))
+			continue
+		}
+
+		var key string
+		switch p := pattern.(type) {
+		case parser.Atom:
+			matchesAll = true
+		case parser.Number:
+			key = "n" + p.V.String()
+		case parser.String:
+			key = "s" + p.V
+		case parser.Unit:
+			key = "u"
+		}
+		if key == "" {
+			continue
+		}
+		if literals[key] {
+			c.warnings = append(c.warnings, warnf(
+				pattern,
+				"unreachable match arm, the same literal is matched by an earlier arm",
+			))
+		}
+		literals[key] = true
+	}
+}
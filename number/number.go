@@ -24,11 +24,31 @@ func FromInt(x int) Number {
 	return Number{r}
 }
 
+func FromInt64(x int64) Number {
+	var r big.Rat
+	r.Num().SetInt64(x)
+	return Number{r}
+}
+
+func FromUint64(x uint64) Number {
+	var r big.Rat
+	r.Num().SetUint64(x)
+	return Number{r}
+}
+
+func FromFloat64(x float64) (Number, error) {
+	var r big.Rat
+	if r.SetFloat64(x) == nil {
+		return Number{}, fmt.Errorf("%v is not a valid number", x)
+	}
+	return Number{r}, nil
+}
+
 func FromString(s string) (Number, error) {
 	var r big.Rat
 	if _, ok := r.SetString(s); !ok {
 		// TODO: better error message
-		return Number{}, fmt.Errorf("%q is not a valid number")
+		return Number{}, fmt.Errorf("%q is not a valid number", s)
 	}
 	return Number{r}, nil
 }
@@ -64,7 +84,7 @@ func (x Number) Neg() Number {
 	return Number{z}
 }
 
-var errZeroDenominator = errors.New("denominator is zero")
+var ErrZeroDenominator = errors.New("denominator is zero")
 
 func (x Number) Mul(y Number) Number {
 	var z big.Rat
@@ -74,7 +94,7 @@ func (x Number) Mul(y Number) Number {
 
 func (x Number) Div(y Number) (Number, error) {
 	if y.r.Sign() == 0 {
-		return Number{}, errZeroDenominator
+		return Number{}, ErrZeroDenominator
 	}
 	var z big.Rat
 	z.Quo(&x.r, &y.r)
@@ -89,7 +109,7 @@ func (x Number) Mod(y Number) (Number, error) {
 		return Number{}, err
 	}
 	if y.r.Sign() == 0 {
-		return Number{}, errZeroDenominator
+		return Number{}, ErrZeroDenominator
 	}
 
 	var z big.Int
@@ -130,6 +150,39 @@ func (x Number) Unsigned() (int, error) {
 	return int(i64), nil
 }
 
+func (x Number) IsInt() bool {
+	return x.r.IsInt()
+}
+
+func (x Number) Int64() (int64, error) {
+	if err := x.checkInt(); err != nil {
+		return 0, err
+	}
+	if !x.r.Num().IsInt64() {
+		return 0, fmt.Errorf("%s is too large", x)
+	}
+	return x.r.Num().Int64(), nil
+}
+
+func (x Number) Uint64() (uint64, error) {
+	if err := x.checkInt(); err != nil {
+		return 0, err
+	}
+	if x.r.Num().Sign() < 0 {
+		return 0, fmt.Errorf("%s is smaller than 0", x)
+	}
+	if !x.r.Num().IsUint64() {
+		return 0, fmt.Errorf("%s is too large", x)
+	}
+	return x.r.Num().Uint64(), nil
+}
+
+// Float64 returns the nearest float64 value of x.
+func (x Number) Float64() float64 {
+	f, _ := x.r.Float64()
+	return f
+}
+
 func (x Number) checkInt() error {
 	if !x.r.IsInt() {
 		return fmt.Errorf("%s is not an integer", x)
@@ -90,6 +90,9 @@ func (l *Lexer) Next() (Token, error) {
 
 var errBareTick = errors.New("bare '")
 
+// keywords are read as atoms, so they can't be rebound.
+var keywords = map[string]bool{"catch": true}
+
 func (l *Lexer) next() (Token, error) {
 	ch, line, column, err := l.readRune()
 	if err != nil {
@@ -187,6 +190,9 @@ func (l *Lexer) next() (Token, error) {
 		if err != nil {
 			return nil, err
 		}
+		if keywords[ref] {
+			return Atom{l.path, line, column, ref}, nil
+		}
 		return Ref{l.path, line, column, ref}, nil
 	case isNumberStart(ch):
 		// TODO: support hex, binary, octal
@@ -206,6 +212,22 @@ func (l *Lexer) next() (Token, error) {
 			panic(internal + ": " + err.Error())
 		}
 		return Number{l.path, line, column, n}, nil
+	case ch == '%':
+		next, _, _, err := l.readRune()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == nil {
+			if next == '[' {
+				return OpenMap{l.path, line, column}, nil
+			}
+			l.unreadRune()
+		}
+		symbol, err := l.takeStringWhile(isSymbol)
+		if err != nil {
+			return nil, err
+		}
+		return Symbol{l.path, line, column, "%" + symbol}, nil
 	case isSymbol(ch):
 		l.unreadRune()
 		symbol, err := l.takeStringWhile(isSymbol)
@@ -157,6 +157,12 @@ func (p *parser) group(pos Positioned, explicitBracket bool, errorOnSymbol bool)
 				return nil, err
 			}
 			g = append(g, l)
+		case OpenMap:
+			m, err := p.mapLiteral(t)
+			if err != nil {
+				return nil, err
+			}
+			g = append(g, m)
 		case ClosedCurly:
 			if explicitBracket {
 				return nil, errorf(t, "unexpected '}'")
@@ -210,6 +216,12 @@ func (p *parser) list(pos Positioned) (Element, error) {
 				return nil, err
 			}
 			l.V = append(l.V, ll)
+		case OpenMap:
+			m, err := p.mapLiteral(t)
+			if err != nil {
+				return nil, err
+			}
+			l.V = append(l.V, m)
 		case ClosedCurly:
 			return l, errorf(t, "unexpected '}'")
 		case ClosedSquare:
@@ -219,3 +231,16 @@ func (p *parser) list(pos Positioned) (Element, error) {
 		}
 	}
 }
+
+func (p *parser) mapLiteral(pos Positioned) (Element, error) {
+	l, err := p.list(pos)
+	if err != nil {
+		return nil, err
+	}
+	v := l.(List).V
+	if len(v)%2 != 0 {
+		return nil, errorf(pos, "map literal needs key and value pairs, got %d elements", len(v))
+	}
+	path, line, col := pos.Position()
+	return Map{Path: path, Line: line, Column: col, V: v}, nil
+}
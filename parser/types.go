@@ -142,6 +142,15 @@ func (OpenSquare) token() {}
 
 func (os OpenSquare) Position() (string, int, int) { return os.Path, os.Line, os.Column }
 
+type OpenMap struct {
+	Path         string
+	Line, Column int
+}
+
+func (OpenMap) token() {}
+
+func (om OpenMap) Position() (string, int, int) { return om.Path, om.Line, om.Column }
+
 type ClosedSquare struct {
 	Path         string
 	Line, Column int
@@ -199,3 +208,13 @@ type Block struct {
 func (Block) element() {}
 
 func (b Block) Position() (string, int, int) { return b.Path, b.Line, b.Column }
+
+type Map struct {
+	Path         string
+	Line, Column int
+	V            []Element
+}
+
+func (Map) element() {}
+
+func (m Map) Position() (string, int, int) { return m.Path, m.Line, m.Column }
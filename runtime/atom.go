@@ -6,10 +6,18 @@ var tagAtom = value.NewTag()
 
 type Atom string
 
+func NewAtom(s string) Atom {
+	return Atom(s)
+}
+
 func (Atom) Tag() value.Tag {
 	return tagAtom
 }
 
+func (a Atom) AsString() string {
+	return string(a)
+}
+
 func eqAtom(a Atom, v value.Value) (value.Value, error) {
 	a2, ok := v.(Atom)
 	return NewBool(ok && a == a2), nil
@@ -19,7 +27,13 @@ func stringerAtom(a Atom) (value.Value, error) {
 	return String(string(a)), nil
 }
 
+// wildcard matches any value without binding it.
+const wildcard Atom = "_"
+
 func matcherAtom(a Atom, v value.Value) (value.Value, error) {
+	if a == wildcard {
+		return List{[]value.Value{trueValue, List{}}}, nil
+	}
 	return List{[]value.Value{
 		trueValue,
 		List{[]value.Value{
@@ -14,86 +14,275 @@ var tagBlock = value.NewTag()
 
 type Block interface {
 	value.Value
-	runWithoutEnv(args ...value.Value) (value.Value, error)
-	runWithEnv(env *Environment, args ...value.Value) (*Environment, value.Value, error)
+	runWithoutEnv(t *thread, args ...value.Value) (value.Value, error)
+	runWithEnv(t *thread, env *Environment, args ...value.Value) (*Environment, value.Value, error)
+}
+
+const anonymous = "<anonymous>"
+
+// namedBlock is implemented by blocks which take the name
+// they are first bound to.
+type namedBlock interface {
+	Block
+	blockName() string
+	withName(name string) Block
+}
+
+// codeBlock is implemented by blocks running Quinn code.
+// Calls to them in tail position don't grow the Go stack.
+type codeBlock interface {
+	Block
+	enter(t *thread, args []value.Value) (*Environment, parser.Block, error)
+	// frameName returns the name pushed onto the call stack, if any
+	frameName() (string, bool)
+	// activation returns the function activation the code runs in
+	// and whether it was started by this call
+	activation() (fn *activation, started bool)
 }
 
 type basicBlock struct {
 	env  *Environment
 	code parser.Block
+	name string
+
+	// fn is the activation the block was created in
+	fn *activation
+	// function blocks start a new activation when called
+	function bool
 }
 
 func (basicBlock) Tag() value.Tag {
 	return tagBlock
 }
 
-func (b basicBlock) runWithoutEnv(args ...value.Value) (value.Value, error) {
-	_, v, err := runCode(b.env, b.code, args...)
+func (b basicBlock) runWithoutEnv(t *thread, args ...value.Value) (value.Value, error) {
+	_, v, err := runCode(t, b, args)
 	return v, err
 }
 
-func (b basicBlock) runWithEnv(env *Environment, args ...value.Value) (*Environment, value.Value, error) {
-	_, v, err := runCode(b.env, b.code, args...)
+func (b basicBlock) runWithEnv(t *thread, env *Environment, args ...value.Value) (*Environment, value.Value, error) {
+	v, err := b.runWithoutEnv(t, args...)
 	return env, v, err
 }
 
-func runCode(env *Environment, code parser.Block, args ...value.Value) (*Environment, value.Value, error) {
+func (b basicBlock) enter(t *thread, args []value.Value) (*Environment, parser.Block, error) {
 	switch len(args) {
 	case 0:
 	case 1:
 		if _, isUnit := args[0].(Unit); !isUnit {
-			return nil, nil, fmt.Errorf(
+			return nil, parser.Block{}, fmt.Errorf(
 				"first argument in call to basic block must be unit, not %s",
-				valueString(args[0]),
+				valueString(t, args[0]),
 			)
 		}
 	default:
-		return nil, nil, fmt.Errorf(
+		return nil, parser.Block{}, fmt.Errorf(
 			"too many arguments in call to basic block (%d)",
 			len(args),
 		)
 	}
+	return b.env.newScope(), b.code, nil
+}
 
-	if len(code.V) == 0 {
-		return env, unit, nil
+func (b basicBlock) frameName() (string, bool) {
+	return b.name, b.name != ""
+}
+
+func (b basicBlock) activation() (*activation, bool) {
+	if b.function {
+		return &activation{}, true
 	}
+	return b.fn, false
+}
 
-	for _, elem := range code.V[:len(code.V)-1] {
-		var (
-			v   value.Value
-			err error
-		)
-		env, v, err = evalElement(env, elem)
+func (b basicBlock) blockName() string {
+	return b.name
+}
+
+func (b basicBlock) withName(name string) Block {
+	b.name = name
+	return b
+}
+
+// runCode runs the code of b. Tail calls to other code blocks
+// replace the code run in the loop instead of growing the Go stack.
+// The returned environment is the one after the last statement
+// of the code of b or the one at the first tail call.
+// Returns targeting a function started in the loop end the loop.
+func runCode(t *thread, b codeBlock, args []value.Value) (retEnv *Environment, retV value.Value, err error) {
+	if err := t.enterCall(); err != nil {
+		return nil, nil, err
+	}
+	defer t.exitCall()
+
+	// The site of the first tail call to a named block is kept
+	// as a single frame for all tail calls made in the loop.
+	var (
+		site     parser.Call
+		siteName string
+		hasSite  bool
+	)
+	defer func() {
+		if err != nil && hasSite {
+			positionLimit(err, site.Path, site.Line, site.Column)
+			err = PositionedError{site.Path, site.Line, site.Column, siteName, err, t.inst.sources}
+		}
+	}()
+
+	pushed := false
+	defer func() {
+		if pushed {
+			t.pop()
+		}
+	}()
+
+	var owned *activation
+	outerFn := t.fn
+	defer func() {
+		t.fn = outerFn
+		if owned == nil {
+			return
+		}
+		owned.finish()
+		if err == nil {
+			return
+		}
+		var rs returnSignal
+		if errors.As(err, &rs) && rs.fn == owned {
+			retEnv, retV, err = nil, rs.v, nil
+			return
+		}
+		if signal, ok := loopSignal(err); ok {
+			err = replaceCause(err, errors.New(signal.Error()))
+		}
+	}()
+
+	var (
+		outEnv     *Environment
+		tailCalled bool
+	)
+	result := func(env *Environment, v value.Value) (*Environment, value.Value, error) {
+		if tailCalled {
+			return outEnv, v, nil
+		}
+		return env, v, nil
+	}
+
+	var tailSite parser.Call
+	for {
+		if err := t.checkContext(); err != nil {
+			return nil, nil, err
+		}
+		if err := t.limits.step(); err != nil {
+			return nil, nil, err
+		}
+		name, named := b.frameName()
+		atSite := named && tailCalled && !hasSite
+		if atSite {
+			site, siteName, hasSite = tailSite, t.name(), true
+		}
+		env, code, err := b.enter(t, args)
 		if err != nil {
+			if tailCalled && !atSite {
+				return nil, nil, callError(t, tailSite, err)
+			}
 			return nil, nil, err
 		}
+		if named {
+			if pushed {
+				t.pop()
+			}
+			t.push(name)
+			pushed = true
+		}
+		fn, started := b.activation()
+		if started {
+			if owned != nil {
+				owned.finish()
+			}
+			owned = fn
+		}
+		t.fn = fn
 
-		if _, err := getAttribute(v, tagReturner); err != nil {
-			continue
+		if len(code.V) == 0 {
+			return result(env, unit)
 		}
-		return env, v, nil
+
+		for _, elem := range code.V[:len(code.V)-1] {
+			env, _, err = evalElement(t, env, elem)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		last := code.V[len(code.V)-1]
+		call, ok := last.(parser.Call)
+		if !ok {
+			env, v, err := evalElement(t, env, last)
+			if err != nil {
+				return nil, nil, err
+			}
+			return result(env, v)
+		}
+
+		var next Block
+		env, next, args, err = evalCallParts(t, env, call)
+		if err != nil {
+			return nil, nil, err
+		}
+		for {
+			tb, ok := next.(tailBlock)
+			if !ok {
+				break
+			}
+			v, err := tb.b.runWithoutEnv(t, args...)
+			if err != nil {
+				return nil, nil, callError(t, call, err)
+			}
+			tc, ok := v.(tailCall)
+			if !ok {
+				return result(env, v)
+			}
+			next, args = tc.b, tc.args
+		}
+
+		cb, ok := next.(codeBlock)
+		if !ok {
+			env, v, err := next.runWithEnv(t, env, args...)
+			if err != nil {
+				return nil, nil, callError(t, call, err)
+			}
+			return result(env, v)
+		}
+		if !tailCalled {
+			outEnv, tailCalled = env, true
+		}
+		b, tailSite = cb, call
 	}
-	return evalElement(env, code.V[len(code.V)-1])
 }
 
-func evalElement(env *Environment, element parser.Element) (_ *Environment, v value.Value, err error) {
-	env, v, err = evalElementInner(env, element)
+func evalElement(t *thread, env *Environment, element parser.Element) (_ *Environment, v value.Value, err error) {
+	env, v, err = evalElementInner(t, env, element)
 	if err != nil {
 		if _, ok := err.(PositionedError); ok {
 			return nil, nil, err
 		}
 		path, line, col := element.Position()
-		return nil, nil, PositionedError{path, line, col, err}
+		positionLimit(err, path, line, col)
+		return nil, nil, PositionedError{path, line, col, t.name(), err, t.inst.sources}
 	}
 	return env, v, err
 }
 
-func evalElementInner(env *Environment, element parser.Element) (*Environment, value.Value, error) {
+func evalElementInner(t *thread, env *Environment, element parser.Element) (*Environment, value.Value, error) {
+	if err := t.limits.step(); err != nil {
+		return nil, nil, err
+	}
 	switch v := element.(type) {
 	case parser.Ref:
 		val, ok := env.get(Atom(v.V))
 		if !ok {
-			return nil, nil, fmt.Errorf("unknown variable %s", v.V)
+			return nil, nil, fmt.Errorf("%w %s", errUnknownVariable, v.V)
 		}
 		return env, val, nil
 	case parser.Atom:
@@ -105,87 +294,193 @@ func evalElementInner(env *Environment, element parser.Element) (*Environment, v
 	case parser.Unit:
 		return env, unit, nil
 	case parser.Call:
-		var (
-			val value.Value
-			err error
-		)
-		env, val, err = evalElement(env, v.First)
+		env, b, args, err := evalCallParts(t, env, v)
 		if err != nil {
 			return nil, nil, err
 		}
-		b, ok := val.(Block)
-		if !ok {
-			return nil, nil, fmt.Errorf(
-				"first in call must evaluate to block, got %s instead",
-				valueString(val),
-			)
-		}
-
-		args := make([]value.Value, len(v.Args))
-		for i, e := range v.Args {
-			env, val, err = evalElement(env, e)
-			if err != nil {
-				return nil, nil, err
-			}
-			args[i] = val
-		}
-
-		env, val, err = b.runWithEnv(env, args...)
+		env, val, err := b.runWithEnv(t, env, args...)
 		if err != nil {
-			return nil, nil, PositionedError{v.Path, v.Line, v.Column, err}
+			return nil, nil, callError(t, v, err)
 		}
 		return env, val, nil
 	case parser.List:
+		if err := t.limits.alloc(len(v.V)); err != nil {
+			return nil, nil, err
+		}
 		l := make([]value.Value, len(v.V))
 		for i, e := range v.V {
 			var (
 				v   value.Value
 				err error
 			)
-			env, v, err = evalElement(env, e)
+			env, v, err = evalElement(t, env, e)
 			if err != nil {
 				return nil, nil, err
 			}
 			l[i] = v
 		}
 		return env, List{l}, nil
+	case parser.Map:
+		if err := t.limits.alloc(len(v.V) / 2); err != nil {
+			return nil, nil, err
+		}
+		var m Map
+		for i := 0; i < len(v.V); i += 2 {
+			var (
+				k, val value.Value
+				err    error
+			)
+			env, k, err = evalElement(t, env, v.V[i])
+			if err != nil {
+				return nil, nil, err
+			}
+			env, val, err = evalElement(t, env, v.V[i+1])
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, ok, err := m.get(t, k); err != nil {
+				return nil, nil, err
+			} else if ok {
+				return nil, nil, fmt.Errorf("duplicate key %s in map", valueString(t, k))
+			}
+			if m, err = m.insert(t, k, val); err != nil {
+				return nil, nil, err
+			}
+		}
+		return env, m, nil
 	case parser.Block:
-		return env, basicBlock{env, v}, nil
+		return env, basicBlock{env: env, code: v, fn: t.fn}, nil
 	default:
 		panic(internal)
 	}
 }
 
+func evalCallParts(t *thread, env *Environment, call parser.Call) (*Environment, Block, []value.Value, error) {
+	var (
+		val value.Value
+		err error
+	)
+	env, val, err = evalElement(t, env, call.First)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	b, ok := val.(Block)
+	if !ok {
+		return nil, nil, nil, callError(t, call, fmt.Errorf(
+			"first in call must evaluate to block, got %s instead",
+			valueString(t, val),
+		))
+	}
+
+	args := make([]value.Value, len(call.Args))
+	for i, e := range call.Args {
+		env, val, err = evalElement(t, env, e)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		args[i] = val
+	}
+	return env, b, args, nil
+}
+
+func callError(t *thread, call parser.Call, err error) error {
+	positionLimit(err, call.Path, call.Line, call.Column)
+	return PositionedError{call.Path, call.Line, call.Column, t.name(), err, t.inst.sources}
+}
+
+var tagTailCall = value.NewTag()
+
+// tailCall is returned by the builtins of a tailBlock
+// instead of calling the block themselves.
+type tailCall struct {
+	b    Block
+	args []value.Value
+}
+
+func (tailCall) Tag() value.Tag {
+	return tagTailCall
+}
+
+type tailBlock struct {
+	b Block
+}
+
+func (tailBlock) Tag() value.Tag {
+	return tagBlock
+}
+
+func (b tailBlock) runWithoutEnv(t *thread, args ...value.Value) (value.Value, error) {
+	v, err := b.b.runWithoutEnv(t, args...)
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := v.(tailCall); ok {
+		return tc.b.runWithoutEnv(t, tc.args...)
+	}
+	return v, nil
+}
+
+func (b tailBlock) runWithEnv(t *thread, env *Environment, args ...value.Value) (*Environment, value.Value, error) {
+	v, err := b.runWithoutEnv(t, args...)
+	return env, v, err
+}
+
 type argBlock struct {
-	ref Atom
-	b   basicBlock
+	ref  Atom
+	b    basicBlock
+	name string
 }
 
 func (argBlock) Tag() value.Tag {
 	return tagBlock
 }
 
-func (b argBlock) runWithoutEnv(args ...value.Value) (value.Value, error) {
-	env, ok := b.b.env.insert(b.ref, List{args})
+func (b argBlock) runWithoutEnv(t *thread, args ...value.Value) (value.Value, error) {
+	_, v, err := runCode(t, b, args)
+	return v, err
+}
+
+func (b argBlock) runWithEnv(t *thread, env *Environment, args ...value.Value) (*Environment, value.Value, error) {
+	v, err := b.runWithoutEnv(t, args...)
+	return env, v, err
+}
+
+func (b argBlock) enter(t *thread, args []value.Value) (*Environment, parser.Block, error) {
+	env, ok := b.b.env.newScope().insert(b.ref, List{args})
 	if !ok {
-		return nil, fmt.Errorf(
-			"block already has %s defined in the environment",
-			valueString(b.ref),
+		return nil, parser.Block{}, fmt.Errorf(
+			"block already has %s defined in this scope",
+			valueString(t, b.ref),
 		)
 	}
-	_, v, err := runCode(env, b.b.code)
-	return v, err
+	return env, b.b.code, nil
 }
 
-func (b argBlock) runWithEnv(env *Environment, args ...value.Value) (*Environment, value.Value, error) {
-	v, err := b.runWithoutEnv(args...)
-	return env, v, err
+func (b argBlock) frameName() (string, bool) {
+	if b.name == "" {
+		return anonymous, true
+	}
+	return b.name, true
+}
+
+func (argBlock) activation() (*activation, bool) {
+	return &activation{}, true
+}
+
+func (b argBlock) blockName() string {
+	return b.name
+}
+
+func (b argBlock) withName(name string) Block {
+	b.name = name
+	return b
 }
 
 var (
 	typeValue          = reflect.TypeOf((*value.Value)(nil)).Elem()
 	typeError          = reflect.TypeOf((*error)(nil)).Elem()
 	typePtrEnvironment = reflect.TypeOf((*Environment)(nil))
+	typePtrThread      = reflect.TypeOf((*thread)(nil))
 )
 
 func newBlockMust(fn interface{}) Block {
@@ -202,20 +497,26 @@ func NewBlock(fn interface{}) (Block, error) {
 		return nil, fmt.Errorf("expected func, got %T", fn)
 	}
 
+	i := 0
+	needThread := t.NumIn() > 0 && t.In(0) == typePtrThread
+	if needThread {
+		i++
+	}
+
 	needEnv := false
-	switch numIn := t.NumOut(); numIn {
+	switch numOut := t.NumOut(); numOut {
 	case 2:
-		if numIn < 1 {
+		if t.NumIn() < i+1 {
 			return nil, fmt.Errorf("func %T needs at least 1 argument", fn)
 		}
 	case 3:
-		if numIn < 2 {
+		if t.NumIn() < i+2 {
 			return nil, fmt.Errorf(
 				"func %T needs at least 2 arguments with 3 outputs",
 				fn,
 			)
 		}
-		if t.In(0) != typePtrEnvironment || t.Out(0) != typePtrEnvironment {
+		if t.In(i) != typePtrEnvironment || t.Out(0) != typePtrEnvironment {
 			return nil, fmt.Errorf(
 				"func %T needs an input and output environment with 3 outputs",
 				fn,
@@ -229,20 +530,16 @@ func NewBlock(fn interface{}) (Block, error) {
 		return nil, fmt.Errorf("func %T needs Value and error as last 2 outputs", fn)
 	}
 
-	i := 0
-	inLength := t.NumIn()
 	upperBound := t.NumIn()
 	if needEnv {
 		i++
-		inLength--
 	}
 	if t.IsVariadic() {
-		inLength--
 		upperBound--
 	}
 
-	in := make([]reflect.Type, inLength)
-	for inIdx := 0; i < upperBound; i, inIdx = i+1, inIdx+1 {
+	in := make([]reflect.Type, 0, upperBound-i)
+	for ; i < upperBound; i++ {
 		inType := t.In(i)
 		if !inType.ConvertibleTo(typeValue) {
 			return nil, fmt.Errorf(
@@ -250,7 +547,7 @@ func NewBlock(fn interface{}) (Block, error) {
 				fn,
 			)
 		}
-		in[inIdx] = inType
+		in = append(in, inType)
 	}
 
 	var slice reflect.Type
@@ -265,24 +562,28 @@ func NewBlock(fn interface{}) (Block, error) {
 	}
 
 	if needEnv {
-		return fnBlockWithEnv{in, slice, fn}, nil
+		return fnBlockWithEnv{in, slice, needThread, fn}, nil
 	}
-	return fnBlockWithoutEnv{in, slice, fn}, nil
+	return fnBlockWithoutEnv{in, slice, needThread, fn}, nil
 }
 
 type fnBlockWithoutEnv struct {
-	in    []reflect.Type
-	slice reflect.Type
-	fn    interface{}
+	in         []reflect.Type
+	slice      reflect.Type
+	needThread bool
+	fn         interface{}
 }
 
 func (fnBlockWithoutEnv) Tag() value.Tag {
 	return tagBlock
 }
 
-func (b fnBlockWithoutEnv) runWithoutEnv(args ...value.Value) (value.Value, error) {
-	if fn, ok := b.fn.(func(...value.Value) (value.Value, error)); ok {
+func (b fnBlockWithoutEnv) runWithoutEnv(t *thread, args ...value.Value) (value.Value, error) {
+	switch fn := b.fn.(type) {
+	case func(...value.Value) (value.Value, error):
 		return fn(args...)
+	case func(*thread, ...value.Value) (value.Value, error):
+		return fn(t, args...)
 	}
 
 	isVariadic := b.slice != nil
@@ -290,8 +591,16 @@ func (b fnBlockWithoutEnv) runWithoutEnv(args ...value.Value) (value.Value, erro
 	if isVariadic {
 		inLen++
 	}
+	if b.needThread {
+		inLen++
+	}
 	in := make([]reflect.Value, inLen)
-	if err := prepareReflectCall(in, b.in, b.slice, args...); err != nil {
+	argsIn := in
+	if b.needThread {
+		in[0] = reflect.ValueOf(t)
+		argsIn = in[1:]
+	}
+	if err := prepareReflectCall(t, argsIn, b.in, b.slice, args...); err != nil {
 		return nil, err
 	}
 
@@ -314,24 +623,28 @@ func (b fnBlockWithoutEnv) runWithoutEnv(args ...value.Value) (value.Value, erro
 	return v, err
 }
 
-func (b fnBlockWithoutEnv) runWithEnv(env *Environment, args ...value.Value) (*Environment, value.Value, error) {
-	v, err := b.runWithoutEnv(args...)
+func (b fnBlockWithoutEnv) runWithEnv(t *thread, env *Environment, args ...value.Value) (*Environment, value.Value, error) {
+	v, err := b.runWithoutEnv(t, args...)
 	return env, v, err
 }
 
 type fnBlockWithEnv struct {
-	in    []reflect.Type
-	slice reflect.Type
-	fn    interface{}
+	in         []reflect.Type
+	slice      reflect.Type
+	needThread bool
+	fn         interface{}
 }
 
 func (fnBlockWithEnv) Tag() value.Tag {
 	return tagBlock
 }
 
-func (b fnBlockWithEnv) runWithEnv(env *Environment, args ...value.Value) (*Environment, value.Value, error) {
-	if fn, ok := b.fn.(func(*Environment, ...value.Value) (*Environment, value.Value, error)); ok {
+func (b fnBlockWithEnv) runWithEnv(t *thread, env *Environment, args ...value.Value) (*Environment, value.Value, error) {
+	switch fn := b.fn.(type) {
+	case func(*Environment, ...value.Value) (*Environment, value.Value, error):
 		return fn(env, args...)
+	case func(*thread, *Environment, ...value.Value) (*Environment, value.Value, error):
+		return fn(t, env, args...)
 	}
 
 	isVariadic := b.slice != nil
@@ -339,9 +652,17 @@ func (b fnBlockWithEnv) runWithEnv(env *Environment, args ...value.Value) (*Envi
 	if isVariadic {
 		inLen++
 	}
+	if b.needThread {
+		inLen++
+	}
 	in := make([]reflect.Value, inLen)
-	in[0] = reflect.ValueOf(env)
-	if err := prepareReflectCall(in[1:], b.in, b.slice, args...); err != nil {
+	argsIn := in
+	if b.needThread {
+		in[0] = reflect.ValueOf(t)
+		argsIn = in[1:]
+	}
+	argsIn[0] = reflect.ValueOf(env)
+	if err := prepareReflectCall(t, argsIn[1:], b.in, b.slice, args...); err != nil {
 		return nil, nil, err
 	}
 
@@ -368,11 +689,11 @@ func (b fnBlockWithEnv) runWithEnv(env *Environment, args ...value.Value) (*Envi
 	return next, v, err
 }
 
-func (b fnBlockWithEnv) runWithoutEnv(args ...value.Value) (value.Value, error) {
+func (b fnBlockWithEnv) runWithoutEnv(t *thread, args ...value.Value) (value.Value, error) {
 	return nil, errors.New("can't run this block without an environment")
 }
 
-func prepareReflectCall(in []reflect.Value, inTypes []reflect.Type, slice reflect.Type, args ...value.Value) error {
+func prepareReflectCall(t *thread, in []reflect.Value, inTypes []reflect.Type, slice reflect.Type, args ...value.Value) error {
 	isVariadic := slice != nil
 
 	expected, got := len(inTypes), len(args)
@@ -384,17 +705,17 @@ func prepareReflectCall(in []reflect.Value, inTypes []reflect.Type, slice reflec
 	}
 
 	for i := range inTypes {
-		t, arg := inTypes[i], args[i]
+		inType, arg := inTypes[i], args[i]
 		v := reflect.ValueOf(arg)
-		if !v.Type().ConvertibleTo(t) {
+		if !v.Type().ConvertibleTo(inType) {
 			// TODO: better error message for inType
 			return fmt.Errorf(
 				"argument error: expected %s, got %s",
-				t.String(),
-				valueString(arg),
+				inType.String(),
+				valueString(t, arg),
 			)
 		}
-		in[i] = v.Convert(t)
+		in[i] = v.Convert(inType)
 	}
 
 	if isVariadic {
@@ -413,7 +734,7 @@ func prepareReflectCall(in []reflect.Value, inTypes []reflect.Type, slice reflec
 				return fmt.Errorf(
 					"argument error: expected %s, got %s",
 					to.String(),
-					valueString(vv),
+					valueString(t, vv),
 				)
 			}
 			s.Index(i).Set(v.Convert(to))
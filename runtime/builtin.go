@@ -14,29 +14,92 @@ var builtinOther = []struct {
 }{
 	{"false", falseValue},
 	{"true", trueValue},
-	{"tagReturner", tagReturner},
 	{"tagEq", tagEq},
 	{"tagStringer", tagStringer},
 	{"tagMatcher", tagMatcher},
+	{"tagProtocols", tagProtocols},
+	{"protocolEq", protocolEq},
+	{"protocolStringer", protocolStringer},
+	{"protocolMatcher", protocolMatcher},
+	{"stop", stop},
 }
 
+// catchMarker is the atom the keyword catch is read as.
+var catchMarker value.Value = Atom("catch")
+
 var (
 	errNonBasicBlock     = errors.New("can't use non basic block")
-	errInvalidAttributes = errors.New("attributes must be lists of unique tag and value pairs")
+	errInvalidAttributes = errors.New("attributes must be a map or lists of unique tag and value pairs")
 	errUnopaqueBadTag    = errors.New("can't unopaque: tag doesn't match")
 )
 
+// tailFunc marks builtins which return a tailCall.
+type tailFunc struct {
+	fn interface{}
+}
+
+func tail(fn interface{}) tailFunc {
+	return tailFunc{fn}
+}
+
 var builtinBlocks = []struct {
 	name Atom
 	fn   interface{}
 }{
-	{"default", func(b Block, default_ Block) (value.Value, error) {
-		v, err := b.runWithoutEnv(unit)
+	{"default", func(t *thread, b Block, default_ Block) (value.Value, error) {
+		v, err := b.runWithoutEnv(t, unit)
+		if err != nil && !uncatchable(err) {
+			return default_.runWithoutEnv(t, unit)
+		}
 		if err != nil {
-			return default_.runWithoutEnv(unit)
+			return nil, err
 		}
 		return v, nil
 	}},
+	{"raise", func(t *thread, v value.Value) (value.Value, error) {
+		return nil, raised{v, t.inst}
+	}},
+	{"try", func(t *thread, b Block, args ...value.Value) (value.Value, error) {
+		if len(args) == 2 && args[0] == catchMarker {
+			args = args[1:]
+		}
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expected try BLOCK [catch] HANDLER")
+		}
+		handler, ok := args[0].(Block)
+		if !ok {
+			return nil, fmt.Errorf("handler must be a block, got %s", valueString(t, args[0]))
+		}
+
+		v, err := b.runWithoutEnv(t, unit)
+		if err == nil || uncatchable(err) {
+			return v, err
+		}
+		stack, stackErr := stackValue(t, err)
+		if stackErr != nil {
+			return nil, stackErr
+		}
+		return handler.runWithoutEnv(t, errorValue(t, err), stack)
+	}},
+	{"error", func(kind Atom, message value.Value, data ...value.Value) (value.Value, error) {
+		switch len(data) {
+		case 0:
+			return Error{kind, message, unit}, nil
+		case 1:
+			return Error{kind, message, data[0]}, nil
+		default:
+			return nil, fmt.Errorf("expected 2 or 3 arguments, got %d", 2+len(data))
+		}
+	}},
+	{"errorKind", func(e Error) (value.Value, error) {
+		return e.kind, nil
+	}},
+	{"errorMessage", func(e Error) (value.Value, error) {
+		return e.message, nil
+	}},
+	{"errorData", func(e Error) (value.Value, error) {
+		return e.data, nil
+	}},
 	{"atom", func(s String) (value.Value, error) {
 		return Atom(s), nil
 	}},
@@ -46,27 +109,41 @@ var builtinBlocks = []struct {
 	{"tag", func(v value.Tag) (value.Value, error) {
 		return v.Tag(), nil
 	}},
-	{"attr", func(v value.Value, attr value.Tag) (value.Value, error) {
-		return getAttribute(v, attr)
+	{"attr", func(t *thread, v value.Value, attr value.Tag) (value.Value, error) {
+		return getAttribute(t, v, attr)
 	}},
-	{"opaque", func(v value.Value, tag value.Tag, attrs ...List) (value.Value, error) {
-		// TODO: use Map as attrs when implemented
-
+	{"opaque", func(t *thread, v value.Value, tag value.Tag, attrs ...value.Value) (value.Value, error) {
 		m := make(map[value.Tag]value.Value)
-		for _, pair := range attrs {
-			if len(pair.data) != 2 {
-				return nil, errInvalidAttributes
-			}
-			tagV, attr := pair.data[0], pair.data[1]
+		add := func(tagV, attr value.Value) error {
 			tag, ok := tagV.(value.Tag)
 			if !ok {
-				return nil, errInvalidAttributes
+				return errInvalidAttributes
 			}
-
 			if _, ok := m[tag]; ok {
-				return nil, errInvalidAttributes
+				return errInvalidAttributes
 			}
 			m[tag] = attr
+			return nil
+		}
+
+		if len(attrs) == 1 {
+			if attrMap, ok := attrs[0].(Map); ok {
+				for _, e := range attrMap.h.entries() {
+					if err := add(e.key, e.value); err != nil {
+						return nil, err
+					}
+				}
+				attrs = nil
+			}
+		}
+		for _, pairV := range attrs {
+			pair, ok := pairV.(List)
+			if !ok || len(pair.data) != 2 {
+				return nil, errInvalidAttributes
+			}
+			if err := add(pair.data[0], pair.data[1]); err != nil {
+				return nil, err
+			}
 		}
 
 		o := Opaque{
@@ -74,8 +151,30 @@ var builtinBlocks = []struct {
 			v:     v,
 			attrs: m,
 		}
+		if protocols, ok := m[tagProtocols]; ok {
+			if err := checkProtocols(t, o.attr, protocols); err != nil {
+				return nil, fmt.Errorf("opaque value %w", err)
+			}
+		}
 		return o, nil
 	}},
+	{"protocol", func(name value.Value, reqs Map) (value.Value, error) {
+		atom, ok := name.(Atom)
+		if !ok {
+			return nil, errors.New("protocol name must be an atom")
+		}
+		return newProtocol(atom, reqs)
+	}},
+	{"implements", func(t *thread, v value.Value, p *Protocol) (value.Value, error) {
+		return NewBool(p.check(valueAttrs(t, v)) == nil), nil
+	}},
+	{"arity", func(b Block) (value.Value, error) {
+		min, max := blockArity(b)
+		if max < 0 {
+			return List{[]value.Value{number.FromInt(min), unit}}, nil
+		}
+		return List{[]value.Value{number.FromInt(min), number.FromInt(max)}}, nil
+	}},
 	{"unopaque", func(o Opaque, tag value.Tag) (value.Value, error) {
 		if o.tag != tag {
 			return nil, errUnopaqueBadTag
@@ -86,30 +185,67 @@ var builtinBlocks = []struct {
 		return NewBool(o.tag == tag), nil
 	}},
 	{"mut", func(v value.Value) (value.Value, error) {
-		return &Mut{v}, nil
+		return newMut(v), nil
 	}},
-	{"load", func(target *Mut) (value.Value, error) {
-		return target.v, nil
+	{"load", func(t *thread, target *Mut) (value.Value, error) {
+		if t.tx != nil {
+			return t.tx.load(target)
+		}
+		return target.load(), nil
 	}},
-	{"<-", func(target *Mut, v value.Value) (value.Value, error) {
-		target.v = v
+	{"<-", func(t *thread, target *Mut, v value.Value) (value.Value, error) {
+		if t.tx != nil {
+			t.tx.store(target, v)
+		} else {
+			target.store(v)
+		}
 		return unit, nil
 	}},
-	{"=", func(env *Environment, assignee Atom, v value.Value) (*Environment, value.Value, error) {
-		next, ok := env.insert(assignee, v)
-		if !ok {
+	{"update", update},
+	{"cas", cas},
+	{"atomically", atomically},
+	{"=", func(t *thread, env *Environment, assignee value.Value, v value.Value) (*Environment, value.Value, error) {
+		if atom, ok := assignee.(Atom); ok && atom != wildcard {
+			if b, ok := v.(namedBlock); ok && b.blockName() == "" {
+				v = b.withName(string(atom))
+			}
+			next, ok := env.insert(atom, v)
+			if !ok {
+				return nil, nil, fmt.Errorf(
+					"couldn't assign to name, %s already exists in this scope",
+					valueString(t, atom),
+				)
+			}
+			next.define(v)
+			return next, unit, nil
+		}
+
+		matched, pairs, err := runMatcher(t, assignee, v)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !matched {
 			return nil, nil, fmt.Errorf(
-				"couldn't assign to name, %s already exists",
-				valueString(assignee),
+				"%w %s, expected %s",
+				errNoMatch,
+				valueString(t, v),
+				valueString(t, assignee),
 			)
 		}
+		next, err := bindPairs(t, env, pairs)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, pair := range pairs {
+			next.define(pair.(List).data[1])
+		}
 		return next, unit, nil
 	}},
-	{"==", func(x, y value.Value) (value.Value, error) {
-		return eq(x, y)
+	{"==", func(t *thread, x, y value.Value) (value.Value, error) {
+		return eq(t, x, y)
 	}},
-	{"!=", func(x, y value.Value) (value.Value, error) {
-		bV, err := eq(x, y)
+	{"!=", func(t *thread, x, y value.Value) (value.Value, error) {
+		bV, err := eq(t, x, y)
 		if err != nil {
 			return nil, err
 		}
@@ -117,7 +253,7 @@ var builtinBlocks = []struct {
 		if !ok {
 			return nil, fmt.Errorf(
 				"can't negate non bool value %s",
-				valueString(bV),
+				valueString(t, bV),
 			)
 		}
 		return NewBool(!b.AsBool()), nil
@@ -160,43 +296,69 @@ var builtinBlocks = []struct {
 		if !ok {
 			return nil, errNonBasicBlock
 		}
-		return argBlock{ref, bb}, nil
+		return argBlock{ref, bb, ""}, nil
+	}},
+	{"def", func(t *thread, params List, body Block) (value.Value, error) {
+		return newFuncBlock(t, params, body)
+	}},
+	{"->", func(t *thread, params List, body Block) (value.Value, error) {
+		return newFuncBlock(t, params, body)
+	}},
+	{"rest", func(name value.Value) (value.Value, error) {
+		atom, ok := name.(Atom)
+		if !ok {
+			return nil, errors.New("rest parameter name must be an atom")
+		}
+		return restParam{atom}, nil
+	}},
+	{":", func(name value.Value, v value.Value) (value.Value, error) {
+		atom, ok := name.(Atom)
+		if !ok {
+			return nil, errors.New("keyword argument name must be an atom")
+		}
+		return keywordArg{atom, v}, nil
+	}},
+	{"blockName", func(b Block) (value.Value, error) {
+		nb, ok := b.(namedBlock)
+		if !ok || nb.blockName() == "" {
+			return unit, nil
+		}
+		return String(nb.blockName()), nil
+	}},
+	{"blockParams", func(b Block) (value.Value, error) {
+		fb, ok := b.(*funcBlock)
+		if !ok {
+			return nil, errors.New("block has no parameter list")
+		}
+		return fb.paramList(), nil
 	}},
-	{"insertAndCall", func(kv List, b Block) (value.Value, error) {
+	{"insertAndCall", tail(func(t *thread, kv List, b Block) (value.Value, error) {
 		bb, ok := b.(basicBlock)
 		if !ok {
 			return nil, errNonBasicBlock
 		}
 
-		const errMsg = "expected a list of unique atom and value pairs" +
-			", got %s instead"
-		env, ok := bb.env, false
-		for _, pairV := range kv.data {
-			pair, ok := pairV.(List)
-			if !ok {
-				return nil, fmt.Errorf(errMsg, valueString(kv))
-			}
-			if len(pair.data) != 2 {
-				return nil, fmt.Errorf(errMsg, valueString(kv))
-			}
-			atomV, v := pair.data[0], pair.data[1]
-			atom, ok := atomV.(Atom)
-			if !ok {
-				return nil, fmt.Errorf(errMsg, valueString(kv))
-			}
-			env, ok = env.insert(atom, v)
-			if !ok {
-				return nil, fmt.Errorf(
-					"can't use %s as an argument, already exists in the environment",
-					valueString(atom),
-				)
-			}
+		env, err := bindPairs(t, bb.env.newScope(), kv.data)
+		if err != nil {
+			return nil, err
 		}
-
-		_, v, err := runCode(env, bb.code)
-		return v, err
+		return tailCall{basicBlock{env: env, code: bb.code, function: true}, nil}, nil
+	})},
+	{"match", tail(match)},
+	{"|", func(x, y value.Value) (value.Value, error) {
+		return newOrPattern(x, y), nil
+	}},
+	{"guard", func(pattern value.Value, cond Block) (value.Value, error) {
+		bb, ok := cond.(basicBlock)
+		if !ok {
+			return nil, errNonBasicBlock
+		}
+		return guardPattern{pattern, bb}, nil
+	}},
+	{"pin", func(v value.Value) (value.Value, error) {
+		return pinPattern{v}, nil
 	}},
-	{"if", func(cond value.Value, tBlock Block, blocks ...Block) (value.Value, error) {
+	{"if", tail(func(cond value.Value, tBlock Block, blocks ...Block) (value.Value, error) {
 		var fBlock Block
 		hasFBlock := false
 		switch len(blocks) {
@@ -211,26 +373,53 @@ var builtinBlocks = []struct {
 		_, isUnit := cond.(Unit)
 		if b, isBool := cond.(Bool); (isBool && !b.AsBool()) || isUnit {
 			if hasFBlock {
-				return fBlock.runWithoutEnv()
+				return tailCall{fBlock, nil}, nil
 			} else {
 				return unit, nil
 			}
 		}
-		return tBlock.runWithoutEnv()
-	}},
-	{"loop", func(block Block) (value.Value, error) {
+		return tailCall{tBlock, nil}, nil
+	})},
+	{"loop", func(t *thread, block Block) (value.Value, error) {
 		for {
-			v, err := block.runWithoutEnv()
-			if err != nil {
-				return nil, err
-			}
-			// TODO: use getAttribute for better error reporting
-			returner, err := getAttributeBlock(v, tagReturner)
-			if err != nil {
+			_, err := block.runWithoutEnv(t)
+			if err == nil {
 				continue
 			}
-			return returner.runWithoutEnv(v)
+			var bs breakSignal
+			if errors.As(err, &bs) {
+				return bs.v, nil
+			}
+			if !errors.As(err, &continueSignal{}) {
+				return nil, err
+			}
+		}
+	}},
+	{"return", func(t *thread, v ...value.Value) (value.Value, error) {
+		if t.fn == nil {
+			return nil, errReturnOutsideFunction
+		}
+		if t.fn.finished() {
+			return nil, errReturnFinished
+		}
+		ret, err := signalValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return nil, returnSignal{t.fn, ret}
+	}},
+	{"break", func(v ...value.Value) (value.Value, error) {
+		ret, err := signalValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return nil, breakSignal{ret}
+	}},
+	{"continue", func(v ...value.Value) (value.Value, error) {
+		if _, err := signalValue(v); err != nil {
+			return nil, err
 		}
+		return nil, continueSignal{}
 	}},
 	{"@", func(l List, idx number.Number) (value.Value, error) {
 		i, err := idx.Unsigned()
@@ -239,24 +428,40 @@ var builtinBlocks = []struct {
 		}
 		if i >= len(l.data) {
 			return nil, fmt.Errorf(
-				"index out of range (%d with length %d)",
+				"%w (%d with length %d)",
+				errIndexOutOfRange,
 				i,
 				len(l.data),
 			)
 		}
 		return l.data[i], nil
 	}},
-	{"len", func(l List) (value.Value, error) {
-		return number.FromInt(len(l.data)), nil
+	{"len", func(t *thread, v value.Value) (value.Value, error) {
+		switch c := v.(type) {
+		case List:
+			return number.FromInt(len(c.data)), nil
+		case Map:
+			return number.FromInt(c.h.len), nil
+		case Set:
+			return number.FromInt(c.h.len), nil
+		default:
+			return nil, fmt.Errorf("can't get length of %s", valueString(t, v))
+		}
 	}},
-	{"append", func(l List, v value.Value) (value.Value, error) {
+	{"append", func(t *thread, l List, v value.Value) (value.Value, error) {
+		if err := t.limits.alloc(len(l.data) + 1); err != nil {
+			return nil, err
+		}
 		next := make([]value.Value, len(l.data)+1)
 		copy(next, l.data)
 		next[len(next)-1] = v
 		return List{next}, nil
 	}},
-	{"append_list", func(l, l2 List) (value.Value, error) {
+	{"append_list", func(t *thread, l, l2 List) (value.Value, error) {
 		// TODO: if a list is empty, don't copy
+		if err := t.limits.alloc(len(l.data) + len(l2.data)); err != nil {
+			return nil, err
+		}
 		next := make([]value.Value, len(l.data)+len(l2.data))
 		n := copy(next, l.data)
 		copy(next[n:], l2.data)
@@ -288,42 +493,173 @@ var builtinBlocks = []struct {
 		}
 		return List{l.data[from:to]}, nil
 	}},
-	{"call", func(b Block, args List) (value.Value, error) {
-		return b.runWithoutEnv(args.data...)
+	{"get", func(t *thread, m Map, k value.Value) (value.Value, error) {
+		v, ok, err := m.get(t, k)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errKeyNotFound, valueString(t, k))
+		}
+		return v, nil
 	}},
-	{"println", func(args ...value.Value) (value.Value, error) {
-		if len(args) == 0 {
-			return unit, nil
+	{"has", func(t *thread, collection, k value.Value) (value.Value, error) {
+		var (
+			ok  bool
+			err error
+		)
+		switch c := collection.(type) {
+		case Map:
+			_, ok, err = c.get(t, k)
+		case Set:
+			ok, err = c.has(t, k)
+		default:
+			return nil, fmt.Errorf("expected map or set, got %s", valueString(t, collection))
 		}
-		for _, v := range args[:len(args)-1] {
-			if _, err := fmt.Print(valueString(v), " "); err != nil {
-				return nil, err
-			}
+		if err != nil {
+			return nil, err
 		}
-		if _, err := fmt.Println(valueString(args[len(args)-1])); err != nil {
+		return NewBool(ok), nil
+	}},
+	{"insert", func(t *thread, m Map, k, v value.Value) (value.Value, error) {
+		if err := t.limits.alloc(1); err != nil {
 			return nil, err
 		}
-		return unit, nil
+		return m.insert(t, k, v)
+	}},
+	{"delete", func(t *thread, m Map, k value.Value) (value.Value, error) {
+		return m.delete(t, k)
+	}},
+	{"keys", func(t *thread, m Map) (value.Value, error) {
+		if err := t.limits.alloc(m.h.len); err != nil {
+			return nil, err
+		}
+		return List{m.keys()}, nil
+	}},
+	{"values", func(t *thread, m Map) (value.Value, error) {
+		if err := t.limits.alloc(m.h.len); err != nil {
+			return nil, err
+		}
+		return List{m.values()}, nil
+	}},
+	{"set", func(t *thread, l List) (value.Value, error) {
+		if err := t.limits.alloc(len(l.data)); err != nil {
+			return nil, err
+		}
+		return newSet(t, l.data)
+	}},
+	{"add", func(t *thread, s Set, v value.Value) (value.Value, error) {
+		if err := t.limits.alloc(1); err != nil {
+			return nil, err
+		}
+		return s.add(t, v)
+	}},
+	{"remove", func(t *thread, s Set, v value.Value) (value.Value, error) {
+		return s.remove(t, v)
+	}},
+	{"elements", func(t *thread, s Set) (value.Value, error) {
+		if err := t.limits.alloc(s.h.len); err != nil {
+			return nil, err
+		}
+		return List{s.elements()}, nil
 	}},
+	{"union", func(t *thread, s, s2 Set) (value.Value, error) {
+		return s.union(t, s2)
+	}},
+	{"intersection", func(t *thread, s, s2 Set) (value.Value, error) {
+		return s.intersection(t, s2)
+	}},
+	{"difference", func(t *thread, s, s2 Set) (value.Value, error) {
+		return s.difference(t, s2)
+	}},
+	{"subset", func(t *thread, s, s2 Set) (value.Value, error) {
+		ok, err := s.subset(t, s2)
+		if err != nil {
+			return nil, err
+		}
+		return NewBool(ok), nil
+	}},
+	{"call", tail(func(b Block, args List) (value.Value, error) {
+		return tailCall{b, args.data}, nil
+	})},
+	{"sleep", sleep},
+	{"spawn", spawn},
+	{"await", await},
+	{"chan", newChan},
+	{"send", send},
+	{"recv", recv},
+	{"close", closeValue},
+	{"onSend", func(c *Chan, v value.Value) (value.Value, error) {
+		return &selectCase{c: c, send: true, v: v}, nil
+	}},
+	{"onRecv", func(c *Chan) (value.Value, error) {
+		return &selectCase{c: c}, nil
+	}},
+	{"select", tail(select_)},
+	{"generator", newGenerator},
+	{"yield", yield},
+	{"finally", finally},
+	{"environment", func(env *Environment, _ Unit) (*Environment, value.Value, error) {
+		return env, env, nil
+	}},
+	// newEnvironment returns a sandbox: it has no parent, so code run in it
+	// with evalIn only sees the names in bindings, not even the builtins.
+	{"newEnvironment", func(t *thread, bindings Map) (value.Value, error) {
+		next, err := emptyEnvironment(t.inst).extend(t, bindings)
+		if err != nil {
+			return nil, err
+		}
+		return next, nil
+	}},
+	{"bindings", func(t *thread, env *Environment) (value.Value, error) {
+		return env.bindings(t)
+	}},
+	{"extend", func(t *thread, env *Environment, bindings Map) (value.Value, error) {
+		next, err := env.extend(t, bindings)
+		if err != nil {
+			return nil, err
+		}
+		return next, nil
+	}},
+	{"evalIn", tail(func(env *Environment, b Block) (value.Value, error) {
+		bb, ok := b.(basicBlock)
+		if !ok {
+			return nil, errNonBasicBlock
+		}
+		bb.env = env
+		return tailCall{bb, nil}, nil
+	})},
+	{"handle", handle},
+	{"perform", perform},
+	{"print", print_},
+	{"println", println_},
+	{"eprintln", eprintln},
+	{"flush", flush},
+	{"readLine", readLine},
 }
 
-var builtinEnv *Environment = nil
+// builtinEnv is the environment used by Run if none is given.
+var builtinEnv = newBuiltinEnv(defaultInstance)
 
-func init() {
-	var ok bool
+func newBuiltinEnv(inst *instance) *Environment {
+	env, ok := emptyEnvironment(inst), false
 	for _, builtin := range builtinBlocks {
-		builtinEnv, ok = builtinEnv.insert(
-			builtin.name,
-			newBlockMust(builtin.fn),
-		)
+		var b Block
+		if tf, ok := builtin.fn.(tailFunc); ok {
+			b = tailBlock{newBlockMust(tf.fn)}
+		} else {
+			b = newBlockMust(builtin.fn)
+		}
+		env, ok = env.insert(builtin.name, b)
 		if !ok {
 			panic(internal)
 		}
 	}
 	for _, builtin := range builtinOther {
-		builtinEnv, ok = builtinEnv.insert(builtin.name, builtin.value)
+		env, ok = env.insert(builtin.name, builtin.value)
 		if !ok {
 			panic(internal)
 		}
 	}
+	return env
 }
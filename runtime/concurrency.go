@@ -0,0 +1,247 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/erikfastermann/quinn/number"
+	"github.com/erikfastermann/quinn/value"
+)
+
+var (
+	tagTask       = value.NewTag()
+	tagChan       = value.NewTag()
+	tagSelectCase = value.NewTag()
+	tagStop       = value.NewTag()
+)
+
+var (
+	errSignalInTask = errors.New("return, break or continue can't leave a spawned block")
+	errSendClosed   = errors.New("send on closed channel")
+	errCloseClosed  = errors.New("close of closed channel")
+)
+
+// stopValue is received from closed channels
+// and returned by exhausted generators.
+type stopValue struct{}
+
+var stop value.Value = stopValue{}
+
+func (stopValue) Tag() value.Tag {
+	return tagStop
+}
+
+func eqStop(_ stopValue, v value.Value) (value.Value, error) {
+	_, ok := v.(stopValue)
+	return NewBool(ok), nil
+}
+
+func stringerStop(_ stopValue) (value.Value, error) {
+	return String("stop"), nil
+}
+
+// Task is a block running on its own goroutine.
+type Task struct {
+	done chan struct{}
+	// v and err are set before done is closed
+	v   value.Value
+	err error
+}
+
+func (*Task) Tag() value.Tag {
+	return tagTask
+}
+
+func eqTask(task *Task, v value.Value) (value.Value, error) {
+	task2, ok := v.(*Task)
+	return NewBool(ok && task == task2), nil
+}
+
+func stringerTask(*Task) (value.Value, error) {
+	return String("(task)"), nil
+}
+
+// spawn runs b on a new goroutine. The goroutine shares
+// the limits, streams and context of t.
+func spawn(t *thread, b Block) (value.Value, error) {
+	task := &Task{done: make(chan struct{})}
+	child := t.spawn()
+	go func() {
+		defer close(task.done)
+		v, err := b.runWithoutEnv(child)
+		if err != nil && isSignal(err) {
+			err = replaceCause(err, errSignalInTask)
+		}
+		task.v, task.err = v, err
+	}()
+	return task, nil
+}
+
+// await waits for task and returns its result,
+// errors of the task are returned with their traceback.
+func await(t *thread, task *Task) (value.Value, error) {
+	select {
+	case <-task.done:
+		return task.v, task.err
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+}
+
+type Chan struct {
+	ch chan value.Value
+}
+
+func (*Chan) Tag() value.Tag {
+	return tagChan
+}
+
+func eqChan(c *Chan, v value.Value) (value.Value, error) {
+	c2, ok := v.(*Chan)
+	return NewBool(ok && c == c2), nil
+}
+
+func stringerChan(c *Chan) (value.Value, error) {
+	return String(fmt.Sprintf("(chan %d)", cap(c.ch))), nil
+}
+
+func newChan(size number.Number) (value.Value, error) {
+	n, err := size.Unsigned()
+	if err != nil {
+		return nil, fmt.Errorf("channel size is not valid, %w", err)
+	}
+	return &Chan{make(chan value.Value, n)}, nil
+}
+
+func send(t *thread, c *Chan, v value.Value) (_ value.Value, err error) {
+	defer func() {
+		if recover() != nil {
+			err = errSendClosed
+		}
+	}()
+	select {
+	case c.ch <- v:
+		return unit, nil
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+}
+
+// recv returns stop if c is closed and empty.
+func recv(t *thread, c *Chan) (value.Value, error) {
+	select {
+	case v, ok := <-c.ch:
+		if !ok {
+			return stop, nil
+		}
+		return v, nil
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+}
+
+func closeChan(c *Chan) (_ value.Value, err error) {
+	defer func() {
+		if recover() != nil {
+			err = errCloseClosed
+		}
+	}()
+	close(c.ch)
+	return unit, nil
+}
+
+// selectCase is a channel operation used by select.
+type selectCase struct {
+	c    *Chan
+	send bool
+	v    value.Value
+}
+
+func (*selectCase) Tag() value.Tag {
+	return tagSelectCase
+}
+
+func eqSelectCase(sc *selectCase, v value.Value) (value.Value, error) {
+	sc2, ok := v.(*selectCase)
+	return NewBool(ok && sc == sc2), nil
+}
+
+func stringerSelectCase(t *thread, sc *selectCase) (value.Value, error) {
+	if sc.send {
+		return String(fmt.Sprintf(
+			"(onSend %s %s)",
+			valueString(t, sc.c),
+			valueString(t, sc.v),
+		)), nil
+	}
+	return String(fmt.Sprintf("(onRecv %s)", valueString(t, sc.c))), nil
+}
+
+// select_ waits until one of the operations in arms can proceed,
+// then returns a tail call to its block. Received values are passed
+// to the block, stop for a closed channel. With a default block,
+// select_ doesn't wait.
+func select_(t *thread, arms List, default_ ...value.Value) (_ value.Value, err error) {
+	if len(arms.data)%2 != 0 {
+		return nil, fmt.Errorf(
+			"select expects operation and block pairs, got %d elements",
+			len(arms.data),
+		)
+	}
+	if len(default_) > 1 {
+		return nil, fmt.Errorf("expected select ARMS [DEFAULT]")
+	}
+
+	blocks := make([]Block, 0, len(arms.data)/2+1)
+	cases := make([]reflect.SelectCase, 0, len(arms.data)/2+2)
+	for i := 0; i < len(arms.data); i += 2 {
+		sc, ok := arms.data[i].(*selectCase)
+		if !ok {
+			return nil, fmt.Errorf(
+				"expected channel operation, got %s",
+				valueString(t, arms.data[i]),
+			)
+		}
+		b, ok := arms.data[i+1].(Block)
+		if !ok {
+			return nil, fmt.Errorf("expected block, got %s", valueString(t, arms.data[i+1]))
+		}
+		c := reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(sc.c.ch)}
+		if sc.send {
+			c.Dir, c.Send = reflect.SelectSend, reflect.ValueOf(&sc.v).Elem()
+		}
+		cases = append(cases, c)
+		blocks = append(blocks, b)
+	}
+	if len(default_) == 1 {
+		b, ok := default_[0].(Block)
+		if !ok {
+			return nil, fmt.Errorf("expected block, got %s", valueString(t, default_[0]))
+		}
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+		blocks = append(blocks, b)
+	}
+	cases = append(cases, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(t.done),
+	})
+
+	defer func() {
+		if recover() != nil {
+			err = errSendClosed
+		}
+	}()
+	chosen, received, ok := reflect.Select(cases)
+	if chosen == len(blocks) {
+		return nil, t.ctxErr()
+	}
+	if cases[chosen].Dir != reflect.SelectRecv {
+		return tailCall{blocks[chosen], nil}, nil
+	}
+	v := stop
+	if ok {
+		v = received.Interface().(value.Value)
+	}
+	return tailCall{blocks[chosen], []value.Value{v}}, nil
+}
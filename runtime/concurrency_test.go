@@ -0,0 +1,32 @@
+package runtime
+
+import "testing"
+
+func TestConcurrency(t *testing.T) {
+	runCases(t, []evalCase{
+		{name: "await", src: "'got = (await (spawn { 1 + 2 }))", want: "3"},
+		{name: "buffered channel", src: "'c = chan 2\nsend c 1\nsend c 2\n'got = [(recv c) (recv c)]", want: "[1 2]"},
+		{
+			name: "unbuffered channel",
+			src:  "'c = chan 0\nspawn { send c 1 }\n'got = (recv c)",
+			want: "1",
+		},
+		{name: "closed channel", src: "'c = chan 1\nsend c 1\nclose c\n'got = [(recv c) (recv c)]", want: "[1 stop]"},
+		{name: "stop", src: "'got = [(stop == stop) (stop == ())]", want: "[true false]"},
+		{
+			name: "select",
+			src:  "'c = chan 1\nsend c 1\n'got = (select [(onRecv c) (['v] -> { v + 1 })])",
+			want: "2",
+		},
+		{
+			name: "select closed channel",
+			src:  "'c = chan 0\nclose c\n'got = (select [(onRecv c) (['v] -> { v })])",
+			want: "stop",
+		},
+		{name: "select default", src: "'c = chan 0\n'got = (select [(onRecv c) { 1 }] { 2 })", want: "2"},
+		{name: "send on closed channel", src: "'c = chan 1\nclose c\nsend c 1", err: "send on closed channel"},
+		{name: "close twice", src: "'c = chan 1\nclose c\nclose c", err: "close of closed channel"},
+		{name: "error in task", src: "await (spawn { 1 + 'a })", err: "expected number.Number"},
+		{name: "signal in task", src: "await (spawn { break () })", err: "can't leave a spawned block"},
+	})
+}
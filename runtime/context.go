@@ -0,0 +1,68 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/erikfastermann/quinn/number"
+	"github.com/erikfastermann/quinn/parser"
+	"github.com/erikfastermann/quinn/value"
+)
+
+// canceled wraps the error of a done context,
+// it can't be caught by try or default.
+type canceled struct {
+	err error
+}
+
+func (c canceled) Error() string {
+	return c.err.Error()
+}
+
+func (c canceled) Unwrap() error {
+	return c.err
+}
+
+func isCanceled(err error) bool {
+	var c canceled
+	return errors.As(err, &c)
+}
+
+// RunContext is like Run, but stops running block
+// with the error of ctx when ctx is done.
+func RunContext(ctx context.Context, env *Environment, block parser.Block) (*Environment, error) {
+	return RunContextWithOptions(ctx, env, block, Options{})
+}
+
+func RunContextWithOptions(ctx context.Context, env *Environment, block parser.Block, opts Options) (*Environment, error) {
+	if env == nil {
+		env = builtinEnv
+	}
+	t := newThread(defaultInstance, opts)
+	t.setContext(ctx)
+	env, _, err := runCode(t, basicBlock{env: env, code: block}, nil)
+	if finishErr := t.finish(); err == nil {
+		err = finishErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func sleep(t *thread, seconds number.Number) (value.Value, error) {
+	d := time.Duration(seconds.Float64() * float64(time.Second))
+	if d < 0 {
+		return nil, fmt.Errorf("can't sleep for a negative duration")
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return unit, nil
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+}
@@ -0,0 +1,109 @@
+package runtime
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/erikfastermann/quinn/value"
+)
+
+// activation is a single call of a function.
+// return finishes the activation it is lexically written in.
+// Once done, it is only read, possibly by other goroutines.
+type activation struct {
+	done int32
+}
+
+func (a *activation) finish() {
+	atomic.StoreInt32(&a.done, 1)
+}
+
+func (a *activation) finished() bool {
+	return atomic.LoadInt32(&a.done) == 1
+}
+
+// Control signals are passed up the call chain as errors
+// until the function or loop they target is reached.
+
+type returnSignal struct {
+	fn *activation
+	v  value.Value
+}
+
+func (returnSignal) Error() string {
+	return "return outside of a function"
+}
+
+type breakSignal struct {
+	v value.Value
+}
+
+func (breakSignal) Error() string {
+	return "break outside of a loop"
+}
+
+type continueSignal struct{}
+
+func (continueSignal) Error() string {
+	return "continue outside of a loop"
+}
+
+var (
+	errReturnOutsideFunction = errors.New("return outside of a function")
+	errReturnFinished        = errors.New("return from a function which already returned")
+)
+
+func isSignal(err error) bool {
+	var (
+		rs returnSignal
+		bs breakSignal
+		cs continueSignal
+	)
+	return errors.As(err, &rs) || errors.As(err, &bs) || errors.As(err, &cs)
+}
+
+// uncatchable reports if err must not be handled by try or default.
+func uncatchable(err error) bool {
+	return isSignal(err) ||
+		isLimit(err) ||
+		isCanceled(err) ||
+		errors.Is(err, errConflict) ||
+		errors.As(err, &generatorClosed{}) ||
+		errors.As(err, &continuationAborted{})
+}
+
+// loopSignal reports if err is a break or continue signal,
+// which can't leave the function they are used in.
+func loopSignal(err error) (error, bool) {
+	var (
+		bs breakSignal
+		cs continueSignal
+	)
+	if errors.As(err, &bs) {
+		return bs, true
+	}
+	if errors.As(err, &cs) {
+		return cs, true
+	}
+	return nil, false
+}
+
+// replaceCause replaces the error at the end of a chain of positioned errors.
+func replaceCause(err error, cause error) error {
+	if pe, ok := err.(PositionedError); ok {
+		pe.err = replaceCause(pe.err, cause)
+		return pe
+	}
+	return cause
+}
+
+func signalValue(v []value.Value) (value.Value, error) {
+	switch len(v) {
+	case 0:
+		return unit, nil
+	case 1:
+		return v[0], nil
+	default:
+		return nil, errors.New("expected at most one value")
+	}
+}
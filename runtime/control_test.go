@@ -0,0 +1,32 @@
+package runtime
+
+import "testing"
+
+func TestControlSignals(t *testing.T) {
+	runCases(t, []evalCase{
+		{name: "return", src: "'got = (insertAndCall [] {\n\treturn 1\n\t2\n})", want: "1"},
+		{name: "return unit", src: "'got = (insertAndCall [] { return () })", want: "()"},
+		{name: "return from loop", src: "'got = (insertAndCall [] { loop { return 3 } })", want: "3"},
+		{name: "return through default", src: "'got = (insertAndCall [] {\n\tdefault { return 5 } { 6 }\n\t7\n})", want: "5"},
+		{name: "break", src: "'got = (loop { break 4 })", want: "4"},
+		{
+			name: "continue",
+			src: `'i = mut 0
+'sum = mut 0
+loop {
+	i <- ((load i) + 1)
+	if ((load i) > 5) { break () }
+	if (((load i) %% 2) == 0) { continue () }
+	sum <- ((load sum) + (load i))
+}
+'got = (load sum)`,
+			want: "9",
+		},
+		{name: "return outside function", src: "return 1", err: "return outside of a function"},
+		{name: "break outside loop", src: "break 1", err: "break outside of a loop"},
+		{name: "continue outside loop", src: "continue ()", err: "continue outside of a loop"},
+		{name: "break leaving function", src: "loop { insertAndCall [] { break 1 } }", err: "break outside of a loop"},
+		{name: "return after function returned", src: "'f = (insertAndCall [] { { return 1 } })\n'got = (f ())", err: "already returned"},
+		{name: "too many values", src: "loop { break 1 2 }", err: "expected at most one value"},
+	})
+}
@@ -0,0 +1,240 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/erikfastermann/quinn/value"
+)
+
+var (
+	errResumedTwice     = errors.New("continuation was already resumed")
+	errResumeAfterAbort = errors.New("continuation can't be resumed after its handle finished")
+)
+
+// continuationAborted unwinds a computation suspended in perform
+// whose continuation wasn't resumed before its handle finished.
+type continuationAborted struct{}
+
+func (continuationAborted) Error() string {
+	return "continuation aborted"
+}
+
+// handlerFrame is installed by handle. The handled block runs on its
+// own goroutine, forked from the thread of handle, and sends its effects
+// and its result to events.
+type handlerFrame struct {
+	handlers Map
+	events   chan effectEvent
+	outer    *handlerFrame
+
+	mu       sync.Mutex
+	pending  *continuation
+	finished bool
+}
+
+type effectEvent struct {
+	effect, arg value.Value
+	resume      chan resumeMsg
+
+	// set when the handled block finished
+	done bool
+	v    value.Value
+	err  error
+}
+
+type resumeMsg struct {
+	v     value.Value
+	abort bool
+}
+
+// handle runs b with handlers, a map of effects to blocks.
+// A handler is called with the value passed to perform
+// and a one-shot continuation of the suspended computation.
+// The result of the continuation is the result of the rest of b,
+// if the handler doesn't call it, b is aborted.
+func handle(t *thread, b Block, handlers Map) (value.Value, error) {
+	frame := &handlerFrame{
+		handlers: handlers,
+		events:   make(chan effectEvent),
+		outer:    t.handlers,
+	}
+	child := t.fork()
+	child.handlers = frame
+	go func() {
+		v, err := b.runWithoutEnv(child, unit)
+		select {
+		case frame.events <- effectEvent{done: true, v: v, err: err}:
+		case <-child.done:
+		}
+	}()
+
+	v, err := frame.next(t)
+	if abortErr := frame.abort(t); err == nil {
+		err = abortErr
+	}
+	return v, err
+}
+
+// next waits for the next effect or the result of the handled block.
+func (f *handlerFrame) next(t *thread) (value.Value, error) {
+	var ev effectEvent
+	select {
+	case ev = <-f.events:
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+	if ev.done {
+		f.mu.Lock()
+		f.finished = true
+		f.mu.Unlock()
+		return ev.v, ev.err
+	}
+
+	h, _, err := f.handlers.get(t, ev.effect)
+	if err != nil {
+		return nil, err
+	}
+	handler, ok := h.(Block)
+	if !ok {
+		return nil, fmt.Errorf("handler must be a block, got %s", valueString(t, h))
+	}
+	k := &continuation{frame: f, resume: ev.resume}
+	f.mu.Lock()
+	f.pending = k
+	f.mu.Unlock()
+	return handler.runWithoutEnv(t, ev.arg, k)
+}
+
+// abort unwinds the handled block if it is still suspended,
+// effects performed while unwinding are aborted as well.
+func (f *handlerFrame) abort(t *thread) error {
+	f.mu.Lock()
+	finished, k := f.finished, f.pending
+	f.mu.Unlock()
+	if finished || k == nil {
+		return nil
+	}
+	if aborted, err := k.abort(t); !aborted || err != nil {
+		return err
+	}
+	for {
+		select {
+		case ev := <-f.events:
+			if ev.done {
+				return nil
+			}
+			select {
+			case ev.resume <- resumeMsg{abort: true}:
+			case <-t.done:
+				return t.ctxErr()
+			}
+		case <-t.done:
+			return t.ctxErr()
+		}
+	}
+}
+
+const (
+	continuationPending = iota
+	continuationResumed
+	continuationAbortedState
+)
+
+// continuation resumes a computation suspended in perform.
+type continuation struct {
+	frame  *handlerFrame
+	resume chan resumeMsg
+
+	mu    sync.Mutex
+	state int
+}
+
+func (*continuation) Tag() value.Tag {
+	return tagBlock
+}
+
+// abort reports if k was still pending.
+func (k *continuation) abort(t *thread) (bool, error) {
+	k.mu.Lock()
+	if k.state != continuationPending {
+		k.mu.Unlock()
+		return false, nil
+	}
+	k.state = continuationAbortedState
+	k.mu.Unlock()
+	select {
+	case k.resume <- resumeMsg{abort: true}:
+		return true, nil
+	case <-t.done:
+		return false, t.ctxErr()
+	}
+}
+
+func (k *continuation) runWithEnv(t *thread, env *Environment, args ...value.Value) (*Environment, value.Value, error) {
+	v, err := k.runWithoutEnv(t, args...)
+	return env, v, err
+}
+
+// runWithoutEnv passes v to the suspended perform and waits
+// until the handled block performs the next effect or finishes.
+func (k *continuation) runWithoutEnv(t *thread, args ...value.Value) (value.Value, error) {
+	v, err := signalValue(args)
+	if err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	switch k.state {
+	case continuationResumed:
+		k.mu.Unlock()
+		return nil, errResumedTwice
+	case continuationAbortedState:
+		k.mu.Unlock()
+		return nil, errResumeAfterAbort
+	}
+	k.state = continuationResumed
+	k.mu.Unlock()
+
+	select {
+	case k.resume <- resumeMsg{v: v}:
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+	return k.frame.next(t)
+}
+
+// perform passes arg to the handler of effect in the innermost
+// handle handling it and returns the value the computation is resumed with.
+func perform(t *thread, effect, arg value.Value) (value.Value, error) {
+	var frame *handlerFrame
+	for f := t.handlers; f != nil; f = f.outer {
+		_, ok, err := f.handlers.get(t, effect)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			frame = f
+			break
+		}
+	}
+	if frame == nil {
+		return nil, fmt.Errorf("unhandled effect %s", valueString(t, effect))
+	}
+
+	resume := make(chan resumeMsg)
+	select {
+	case frame.events <- effectEvent{effect: effect, arg: arg, resume: resume}:
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+	select {
+	case msg := <-resume:
+		if msg.abort {
+			return nil, continuationAborted{}
+		}
+		return msg.v, nil
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+}
@@ -0,0 +1,73 @@
+package runtime
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/erikfastermann/quinn/number"
+)
+
+func TestHandleKeepsThreadState(t *testing.T) {
+	tests := []struct {
+		name, src string
+		want      int
+	}{
+		{
+			"transaction",
+			`'m = mut 0
+try { atomically {
+	handle { m <- 5 } %[]
+	raise "abort"
+} } catch (['e 's] -> { () })
+load m`,
+			0,
+		},
+		{
+			"generator",
+			`'g = generator { handle { yield 1 } %[] }
+'n = g ()
+n ()`,
+			1,
+		},
+		{
+			"return",
+			`'f = def [] {
+	handle { return 7 } %[]
+	8
+}
+f ()`,
+			7,
+		},
+		{
+			"break",
+			`'f = def [] {
+	loop { handle { break () } %[] }
+	9
+}
+f ()`,
+			9,
+		},
+	}
+	for _, test := range tests {
+		v, err := NewInterpreter(Options{}).EvalString(test.src)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if n, ok := v.(number.Number); !ok || n.Cmp(number.FromInt(test.want)) != 0 {
+			t.Errorf("%s: got %v, want %d", test.name, v, test.want)
+		}
+	}
+}
+
+func TestHandleCountsCallDepth(t *testing.T) {
+	in := NewInterpreter(Options{MaxCallDepth: 100})
+	_, err := in.EvalString(`'r = def ['n] {
+	if (n == 0) { 0 } { 1 + (handle { r (n - 1) } %[]) }
+}
+r 1000`)
+	var le *LimitError
+	if !errors.As(err, &le) || le.Limit != LimitCallDepth {
+		t.Fatalf("expected call depth error, got %v", err)
+	}
+}
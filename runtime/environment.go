@@ -2,70 +2,214 @@ package runtime
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/erikfastermann/quinn/value"
 )
 
+// Environment is a chain of scopes, names in inner scopes
+// shadow the same names in outer scopes.
 type Environment struct {
-	// TODO: use persistent map
+	vars   *scope
+	parent *Environment
+	// group is shared by all versions of the same scope
+	group *scopeGroup
+	// inst is the instance the values in env belong to
+	inst *instance
+}
 
-	key         Atom
-	value       value.Value
-	left, right *Environment
+// emptyEnvironment returns an environment without any names.
+func emptyEnvironment(inst *instance) *Environment {
+	return &Environment{inst: inst}
+}
+
+type scopeGroup struct {
+	// mu guards funcs and their environments
+	mu sync.Mutex
+	// funcs are the functions assigned to names of the scope
+	// which were also created in it
+	funcs []*funcBlock
+}
+
+var tagEnvironment = value.NewTag()
+
+func (*Environment) Tag() value.Tag {
+	return tagEnvironment
+}
+
+func eqEnvironment(env *Environment, v value.Value) (value.Value, error) {
+	env2, ok := v.(*Environment)
+	return NewBool(ok && env == env2), nil
+}
+
+func stringerEnvironment(_ *Environment) (value.Value, error) {
+	return String("(environment)"), nil
+}
+
+// newScope returns an empty scope nested in env.
+func (env *Environment) newScope() *Environment {
+	return &Environment{nil, env, &scopeGroup{}, env.inst}
 }
 
 func (env *Environment) get(k Atom) (value.Value, bool) {
-	// TODO: iterative
+	for ; env != nil; env = env.parent {
+		if v, ok := env.vars.get(k); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
 
-	if env == nil {
+// insert fails if k already exists in the innermost scope.
+func (env *Environment) insert(k Atom, v value.Value) (*Environment, bool) {
+	next, ok := env.vars.insert(k, v)
+	if !ok {
 		return nil, false
 	}
+	return &Environment{next, env.parent, env.group, env.inst}, true
+}
 
-	if k < env.key {
-		return env.left.get(k)
-	} else if k > env.key {
-		return env.right.get(k)
-	} else {
-		return env.value, true
+// define is called after v was assigned to a name in env.
+// The functions of the scope get env as their environment,
+// so they can refer to themselves and to each other regardless of order.
+func (env *Environment) define(v value.Value) {
+	if env.group == nil {
+		return
+	}
+	env.group.mu.Lock()
+	defer env.group.mu.Unlock()
+	if fb, ok := v.(*funcBlock); ok && fb.group == env.group {
+		env.group.funcs = append(env.group.funcs, fb)
+	}
+	for _, fb := range env.group.funcs {
+		fb.env = env
 	}
 }
 
-func (env *Environment) insert(k Atom, v value.Value) (*Environment, bool) {
-	if env == nil {
-		return &Environment{k, v, nil, nil}, true
+// bindings returns the names visible in env and their values.
+func (env *Environment) bindings(t *thread) (Map, error) {
+	var m Map
+	for ; env != nil; env = env.parent {
+		err := env.vars.each(func(k Atom, v value.Value) error {
+			if _, ok, err := m.get(t, k); err != nil || ok {
+				return err
+			}
+			var err error
+			m, err = m.insert(t, k, v)
+			return err
+		})
+		if err != nil {
+			return Map{}, err
+		}
+	}
+	return m, nil
+}
+
+// extend returns a new scope in env with the names and values in bindings.
+// Unnamed functions are copied, the copies don't belong to the scope
+// the functions were created in.
+func (env *Environment) extend(t *thread, bindings Map) (*Environment, error) {
+	next := env.newScope()
+	for _, e := range bindings.h.entries() {
+		name, ok := e.key.(Atom)
+		if !ok {
+			return nil, fmt.Errorf("name must be an atom, got %s", valueString(t, e.key))
+		}
+		v := e.value
+		if b, ok := v.(namedBlock); ok && b.blockName() == "" {
+			v = b.withName(string(name))
+			if fb, ok := v.(*funcBlock); ok {
+				fb.group = nil
+			}
+		}
+		next, _ = next.insert(name, v)
+	}
+	return next, nil
+}
+
+func (env *Environment) String() string {
+	t := env.inst.renderThread()
+	var scopes []string
+	for ; env != nil; env = env.parent {
+		scopes = append(scopes, env.vars.string(t))
+	}
+	for i, j := 0, len(scopes)-1; i < j; i, j = i+1, j-1 {
+		scopes[i], scopes[j] = scopes[j], scopes[i]
+	}
+	return strings.Join(scopes, " | ")
+}
+
+// scope holds the names of a single scope in a persistent binary tree.
+type scope struct {
+	key         Atom
+	value       value.Value
+	left, right *scope
+}
+
+func (s *scope) get(k Atom) (value.Value, bool) {
+	for s != nil {
+		if k < s.key {
+			s = s.left
+		} else if k > s.key {
+			s = s.right
+		} else {
+			return s.value, true
+		}
+	}
+	return nil, false
+}
+
+func (s *scope) insert(k Atom, v value.Value) (*scope, bool) {
+	if s == nil {
+		return &scope{k, v, nil, nil}, true
 	}
 
-	if k < env.key {
-		next, ok := env.left.insert(k, v)
+	if k < s.key {
+		next, ok := s.left.insert(k, v)
 		if !ok {
 			return nil, false
 		}
-		return &Environment{env.key, env.value, next, env.right}, true
-	} else if k > env.key {
-		next, ok := env.right.insert(k, v)
+		return &scope{s.key, s.value, next, s.right}, true
+	} else if k > s.key {
+		next, ok := s.right.insert(k, v)
 		if !ok {
 			return nil, false
 		}
-		return &Environment{env.key, env.value, env.left, next}, true
+		return &scope{s.key, s.value, s.left, next}, true
 	} else {
 		return nil, false
 	}
 }
 
-func (env *Environment) String() string {
-	if env == nil {
+// each calls fn for the names of s in order.
+func (s *scope) each(fn func(Atom, value.Value) error) error {
+	if s == nil {
+		return nil
+	}
+	if err := s.left.each(fn); err != nil {
+		return err
+	}
+	if err := fn(s.key, s.value); err != nil {
+		return err
+	}
+	return s.right.each(fn)
+}
+
+func (s *scope) string(t *thread) string {
+	if s == nil {
 		return ""
 	}
 
-	left := env.left.String()
+	left := s.left.string(t)
 	str := left
 	if len(left) > 0 {
 		str += " "
 	}
 
-	str += fmt.Sprintf("[%s %s]", env.key, valueString(env.value))
+	str += fmt.Sprintf("[%s %s]", s.key, valueString(t, s.value))
 
-	right := env.right.String()
+	right := s.right.string(t)
 	if len(right) > 0 {
 		str += " "
 	}
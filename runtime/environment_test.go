@@ -0,0 +1,61 @@
+package runtime
+
+import "testing"
+
+func TestScopes(t *testing.T) {
+	runCases(t, []evalCase{
+		{name: "local shadows outer", src: "'x = 1\n'f = def ['_] {\n\t'x = 2\n\tx\n}\n'got = [(f ()) x]", want: "[2 1]"},
+		{name: "parameter shadows outer", src: "'x = 1\n'f = def ['x] { x }\n'got = [(f 5) x]", want: "[5 1]"},
+		{name: "block scope", src: "'x = 1\n'got = [(if true {\n\t'x = 3\n\tx\n}) x]", want: "[3 1]"},
+		{name: "match arm", src: "'a = 1\n'got = [(match 2 ['a { a }]) a]", want: "[2 1]"},
+		{name: "builtin", src: "'f = def ['_] {\n\t'len = 5\n\tlen\n}\n'got = [(f ()) (len [1])]", want: "[5 1]"},
+		{name: "closure sees outer", src: "'x = 1\n'f = def ['_] { x }\n'got = (f ())", want: "1"},
+		{name: "same scope", src: "'x = 1\n'x = 2", err: "x already exists in this scope"},
+		{name: "same function scope", src: "'f = def ['x] {\n\t'x = 2\n}\nf 1", err: "x already exists in this scope"},
+	})
+}
+
+func TestEnvironments(t *testing.T) {
+	runCases(t, []evalCase{
+		{name: "bindings", src: "'got = (bindings (newEnvironment %['a 1 'b 2]))", want: "%['a 1 'b 2]"},
+		{
+			name: "bindings of inner scope win",
+			src:  "'e = extend (newEnvironment %['a 1]) %['a 2]\n'got = (get (bindings e) 'a)",
+			want: "2",
+		},
+		{name: "bindings of current scope", src: "'x = 1\n'got = (get (bindings (environment ())) 'x)", want: "1"},
+		{
+			name: "extend keeps original",
+			src:  "'e = newEnvironment %['a 1]\n'e2 = extend e %['b 2]\n'got = [(has (bindings e) 'b) (has (bindings e2) 'b)]",
+			want: "[false true]",
+		},
+		{name: "evalIn", src: "'got = (evalIn (newEnvironment %['a 1 'b 2]) { [a b] })", want: "[1 2]"},
+		{name: "evalIn current scope", src: "'x = 1\n'here = environment ()\n'got = (evalIn here { x + 1 })", want: "2"},
+		{
+			name: "evalIn extended",
+			src:  "'e = extend (environment ()) %['inc (['n] -> { n + 1 })]\n'got = (evalIn e { [(inc 1) (blockName inc)] })",
+			want: "[2 \"inc\"]",
+		},
+		{
+			name: "sandbox",
+			src:  "'got = (evalIn (newEnvironment %['x 1]) { x })",
+			want: "1",
+		},
+		{name: "sandbox has no builtins", src: "evalIn (newEnvironment %[]) { println 1 }", err: "unknown variable println"},
+		{name: "sandbox hides outer names", src: "'x = 1\nevalIn (newEnvironment %['y 2]) { x }", err: "unknown variable x"},
+		{name: "name not an atom", src: "newEnvironment %[1 2]", err: "name must be an atom, got 1"},
+		{name: "evalIn non basic block", src: "evalIn (environment ()) (['_] -> { 1 })", err: "can't use non basic block"},
+	})
+}
+
+func TestExtendCopiesFunctions(t *testing.T) {
+	env, err := runSource("'e = extend (environment ()) %['f (['n] -> { n })]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, _ := env.get("e")
+	f, _ := e.(*Environment).get("f")
+	if fb := f.(*funcBlock); fb.group != nil {
+		t.Error("copy of f shares the scope group it was created in")
+	}
+}
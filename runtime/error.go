@@ -0,0 +1,147 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/erikfastermann/quinn/number"
+	"github.com/erikfastermann/quinn/value"
+)
+
+var (
+	errIndexOutOfRange = errors.New("index out of range")
+	errKeyNotFound     = errors.New("key not found")
+	errUnknownVariable = errors.New("unknown variable")
+)
+
+var errorKinds = []struct {
+	err  error
+	kind Atom
+}{
+	{number.ErrZeroDenominator, "zeroDenominator"},
+	{errIndexOutOfRange, "indexOutOfRange"},
+	{errKeyNotFound, "keyNotFound"},
+	{errUnknownVariable, "unknownVariable"},
+	{errNoMatch, "noMatch"},
+}
+
+const errorKindOther Atom = "error"
+
+var tagError = value.NewTag()
+
+// Error is the value Go errors are converted to when they are caught.
+type Error struct {
+	kind    Atom
+	message value.Value
+	data    value.Value
+}
+
+func (Error) Tag() value.Tag {
+	return tagError
+}
+
+// raised carries the value passed to raise up the call chain.
+type raised struct {
+	v    value.Value
+	inst *instance
+}
+
+func (r raised) Error() string {
+	return valueString(r.inst.renderThread(), r.v)
+}
+
+func errorValue(t *thread, err error) value.Value {
+	var r raised
+	if errors.As(err, &r) {
+		return r.v
+	}
+
+	kind := errorKindOther
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			kind = k.kind
+			break
+		}
+	}
+
+	for {
+		pe, ok := err.(PositionedError)
+		if !ok {
+			break
+		}
+		err = pe.err
+	}
+	return Error{kind, String(err.Error()), unit}
+}
+
+func stackValue(t *thread, err error) (value.Value, error) {
+	frames := make([]value.Value, 0)
+	for {
+		pe, ok := err.(PositionedError)
+		if !ok {
+			return List{frames}, nil
+		}
+		frame, recordErr := newRecord(t,
+			"path", String(pe.Path),
+			"line", number.FromInt(pe.Line),
+			"column", number.FromInt(pe.Column),
+			"name", String(pe.Name),
+		)
+		if recordErr != nil {
+			return nil, recordErr
+		}
+		frames = append(frames, frame)
+		err = pe.err
+	}
+}
+
+func newRecord(t *thread, keyValuePairs ...interface{}) (Map, error) {
+	var m Map
+	for i := 0; i < len(keyValuePairs); i += 2 {
+		var err error
+		m, err = m.insert(t,
+			Atom(keyValuePairs[i].(string)),
+			keyValuePairs[i+1].(value.Value),
+		)
+		if err != nil {
+			return Map{}, err
+		}
+	}
+	return m, nil
+}
+
+func eqError(t *thread, e Error, v value.Value) (value.Value, error) {
+	e2, ok := v.(Error)
+	if !ok || e.kind != e2.kind {
+		return falseValue, nil
+	}
+	return eqList(t,
+		List{[]value.Value{e.message, e.data}},
+		List{[]value.Value{e2.message, e2.data}},
+	)
+}
+
+func stringerError(t *thread, e Error) (value.Value, error) {
+	if _, ok := e.data.(Unit); ok {
+		return String(fmt.Sprintf("(error %s %s)", e.kind, valueString(t, e.message))), nil
+	}
+	return String(fmt.Sprintf(
+		"(error %s %s %s)",
+		e.kind,
+		valueString(t, e.message),
+		valueString(t, e.data),
+	)), nil
+}
+
+// matcherError matches errors of the same kind,
+// the message and data are matched with their own matchers.
+func matcherError(t *thread, matcher Error, v value.Value) (value.Value, error) {
+	candidate, ok := v.(Error)
+	if !ok || matcher.kind != candidate.kind {
+		return noMatch, nil
+	}
+	return matcherList(t,
+		List{[]value.Value{matcher.message, matcher.data}},
+		List{[]value.Value{candidate.message, candidate.data}},
+	)
+}
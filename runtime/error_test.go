@@ -0,0 +1,24 @@
+package runtime
+
+import "testing"
+
+func TestTry(t *testing.T) {
+	runCases(t, []evalCase{
+		{name: "no error", src: "'got = (try { 1 } catch (['e 's] -> { 2 }))", want: "1"},
+		{name: "raise", src: "'got = (try { raise 1 } catch (['e 's] -> { e }))", want: "1"},
+		{name: "without catch", src: "'got = (try { raise 1 } (['e 's] -> { e }))", want: "1"},
+		{name: "error kind", src: "'got = (try { 1 / 0 } catch (['e 's] -> { errorKind e }))", want: "'zeroDenominator"},
+		{
+			name: "catch rebound",
+			src:  "'catch = 1\n'got = (try { raise 1 } catch (['e 's] -> { e }))",
+			want: "1",
+		},
+		{
+			name: "catch shadowed",
+			src:  "'f = def ['catch] { try { raise 2 } catch (['e 's] -> { e }) }\n'got = (f 1)",
+			want: "2",
+		},
+		{name: "catch is an atom", src: "'got = catch", want: "'catch"},
+		{name: "missing handler", src: "try { 1 } catch", err: "handler must be a block"},
+	})
+}
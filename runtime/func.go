@@ -0,0 +1,287 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/erikfastermann/quinn/parser"
+	"github.com/erikfastermann/quinn/value"
+)
+
+var (
+	tagRestParam  = value.NewTag()
+	tagKeywordArg = value.NewTag()
+)
+
+// restParam collects the remaining positional arguments
+// of a call into a list, created with rest.
+type restParam struct {
+	name Atom
+}
+
+func (restParam) Tag() value.Tag {
+	return tagRestParam
+}
+
+func stringerRestParam(t *thread, r restParam) (value.Value, error) {
+	return String(fmt.Sprintf("(rest %s)", valueString(t, r.name))), nil
+}
+
+// keywordArg passes an argument by the name of the parameter, created with :.
+type keywordArg struct {
+	name Atom
+	v    value.Value
+}
+
+func (keywordArg) Tag() value.Tag {
+	return tagKeywordArg
+}
+
+func stringerKeywordArg(t *thread, k keywordArg) (value.Value, error) {
+	return String(fmt.Sprintf("(%s : %s)", valueString(t, k.name), valueString(t, k.v))), nil
+}
+
+type param struct {
+	name Atom
+	// default_ is nil if the parameter is required
+	default_ value.Value
+}
+
+// funcBlock is a block with named parameters, created with def or ->.
+type funcBlock struct {
+	name     string
+	params   []param
+	required int
+	rest     Atom // empty if the block takes no rest parameter
+	env      *Environment
+	// group is the scope group b was created in, see Environment.define
+	group *scopeGroup
+	code  parser.Block
+}
+
+func (b *funcBlock) environment() *Environment {
+	if b.group == nil {
+		return b.env
+	}
+	b.group.mu.Lock()
+	defer b.group.mu.Unlock()
+	return b.env
+}
+
+const errParamMsg = "parameter must be an atom, an atom and default value pair" +
+	" or a rest parameter, got %s"
+
+func newFuncBlock(t *thread, params List, body Block) (*funcBlock, error) {
+	bb, ok := body.(basicBlock)
+	if !ok {
+		return nil, errNonBasicBlock
+	}
+
+	fb := &funcBlock{env: bb.env, code: bb.code}
+	if bb.env != nil {
+		fb.group = bb.env.group
+	}
+	seen := make(map[Atom]bool)
+	for i, v := range params.data {
+		var p param
+		switch v := v.(type) {
+		case Atom:
+			p.name = v
+		case List:
+			if len(v.data) != 2 {
+				return nil, fmt.Errorf(errParamMsg, valueString(t, v))
+			}
+			name, ok := v.data[0].(Atom)
+			if !ok {
+				return nil, fmt.Errorf(errParamMsg, valueString(t, v))
+			}
+			p = param{name, v.data[1]}
+		case restParam:
+			if i != len(params.data)-1 {
+				return nil, fmt.Errorf("rest parameter %s must be the last parameter", valueString(t, v.name))
+			}
+			p.name = v.name
+		default:
+			return nil, fmt.Errorf(errParamMsg, valueString(t, v))
+		}
+
+		if seen[p.name] {
+			return nil, fmt.Errorf("duplicate parameter %s", valueString(t, p.name))
+		}
+		seen[p.name] = true
+
+		if _, ok := v.(restParam); ok {
+			fb.rest = p.name
+			continue
+		}
+		if p.default_ == nil {
+			if fb.required != len(fb.params) {
+				return nil, fmt.Errorf(
+					"required parameter %s can't follow optional parameters",
+					valueString(t, p.name),
+				)
+			}
+			fb.required++
+		}
+		fb.params = append(fb.params, p)
+	}
+	return fb, nil
+}
+
+func (*funcBlock) Tag() value.Tag {
+	return tagBlock
+}
+
+func (b *funcBlock) runWithoutEnv(t *thread, args ...value.Value) (value.Value, error) {
+	_, v, err := runCode(t, b, args)
+	return v, err
+}
+
+func (b *funcBlock) runWithEnv(t *thread, env *Environment, args ...value.Value) (*Environment, value.Value, error) {
+	v, err := b.runWithoutEnv(t, args...)
+	return env, v, err
+}
+
+func (b *funcBlock) enter(t *thread, args []value.Value) (*Environment, parser.Block, error) {
+	values, err := b.bind(t, args)
+	if err != nil {
+		return nil, parser.Block{}, err
+	}
+
+	env := b.environment().newScope()
+	for i, v := range values {
+		name := b.rest
+		if i < len(b.params) {
+			name = b.params[i].name
+		}
+		var ok bool
+		env, ok = env.insert(name, v)
+		if !ok {
+			return nil, parser.Block{}, fmt.Errorf(
+				"can't use %s as a parameter, already exists in this scope",
+				valueString(t, name),
+			)
+		}
+	}
+	return env, b.code, nil
+}
+
+// bind returns the values of the parameters in order,
+// followed by the rest parameter if there is one.
+func (b *funcBlock) bind(t *thread, args []value.Value) ([]value.Value, error) {
+	name, _ := b.frameName()
+	if len(args) == 1 && args[0] == unit && b.required == 0 && b.rest == "" {
+		args = nil
+	}
+	values := make([]value.Value, len(b.params), len(b.params)+1)
+	var (
+		rest       []value.Value
+		positional int
+		keywords   bool
+	)
+	for _, arg := range args {
+		k, ok := arg.(keywordArg)
+		if !ok {
+			if positional < len(b.params) {
+				if values[positional] != nil {
+					return nil, fmt.Errorf(
+						"%s got multiple values for parameter %s",
+						name,
+						valueString(t, b.params[positional].name),
+					)
+				}
+				values[positional] = arg
+			} else {
+				rest = append(rest, arg)
+			}
+			positional++
+			continue
+		}
+
+		keywords = true
+		idx := -1
+		for i, p := range b.params {
+			if p.name == k.name {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("%s has no parameter %s", name, valueString(t, k.name))
+		}
+		if values[idx] != nil {
+			return nil, fmt.Errorf(
+				"%s got multiple values for parameter %s",
+				name,
+				valueString(t, k.name),
+			)
+		}
+		values[idx] = k.v
+	}
+
+	if positional > len(b.params) && b.rest == "" {
+		if b.required == len(b.params) {
+			return nil, fmt.Errorf("%s expects %d argument(s), got %d", name, len(b.params), positional)
+		}
+		return nil, fmt.Errorf("%s expects at most %d argument(s), got %d", name, len(b.params), positional)
+	}
+
+	for i, p := range b.params {
+		if values[i] != nil {
+			continue
+		}
+		if p.default_ != nil {
+			values[i] = p.default_
+			continue
+		}
+		if !keywords && b.required == len(b.params) && b.rest == "" {
+			return nil, fmt.Errorf("%s expects %d argument(s), got %d", name, len(b.params), positional)
+		}
+		return nil, fmt.Errorf("%s is missing an argument for %s", name, valueString(t, p.name))
+	}
+
+	if b.rest != "" {
+		values = append(values, List{rest})
+	}
+	return values, nil
+}
+
+func (b *funcBlock) frameName() (string, bool) {
+	if b.name == "" {
+		return anonymous, true
+	}
+	return b.name, true
+}
+
+func (*funcBlock) activation() (*activation, bool) {
+	return &activation{}, true
+}
+
+func (b *funcBlock) blockName() string {
+	return b.name
+}
+
+func (b *funcBlock) withName(name string) Block {
+	if b.group != nil {
+		b.group.mu.Lock()
+		defer b.group.mu.Unlock()
+	}
+	named := *b
+	named.name = name
+	return &named
+}
+
+// paramList returns the parameters in the form they are passed to def.
+func (b *funcBlock) paramList() List {
+	params := make([]value.Value, 0, len(b.params)+1)
+	for _, p := range b.params {
+		if p.default_ == nil {
+			params = append(params, p.name)
+		} else {
+			params = append(params, List{[]value.Value{p.name, p.default_}})
+		}
+	}
+	if b.rest != "" {
+		params = append(params, restParam{b.rest})
+	}
+	return List{params}
+}
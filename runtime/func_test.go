@@ -0,0 +1,25 @@
+package runtime
+
+import "testing"
+
+func TestParameters(t *testing.T) {
+	runCases(t, []evalCase{
+		{name: "positional", src: "'f = def ['a 'b] { [a b] }\n'got = (f 1 2)", want: "[1 2]"},
+		{name: "default used", src: "'f = def ['a ['b 2]] { [a b] }\n'got = (f 1)", want: "[1 2]"},
+		{name: "default overridden", src: "'f = def ['a ['b 2]] { [a b] }\n'got = (f 1 3)", want: "[1 3]"},
+		{name: "rest", src: "'f = def ['a (rest 'xs)] { [a xs] }\n'got = (f 1 2 3)", want: "[1 [2 3]]"},
+		{name: "empty rest", src: "'f = def ['a (rest 'xs)] { xs }\n'got = (f 1)", want: "[]"},
+		{name: "keyword", src: "'f = def ['a 'b] { [a b] }\n'got = (f ('b : 2) ('a : 1))", want: "[1 2]"},
+		{name: "keyword skips default", src: "'f = def ['a ['b 2] ['c 3]] { [a b c] }\n'got = (f 1 ('c : 4))", want: "[1 2 4]"},
+		{name: "arrow", src: "'got = ((['x] -> { x + 1 }) 1)", want: "2"},
+		{name: "too many", src: "'f = def ['a 'b] { a }\nf 1 2 3", err: "f expects 2 argument(s), got 3"},
+		{name: "too few", src: "'f = def ['a 'b] { a }\nf 1", err: "f expects 2 argument(s), got 1"},
+		{name: "too many with default", src: "'f = def ['a ['b 2]] { a }\nf 1 2 3", err: "f expects at most 2 argument(s), got 3"},
+		{name: "missing", src: "'f = def ['a ['b 2]] { a }\nf ('b : 1)", err: "f is missing an argument for a"},
+		{name: "unknown keyword", src: "'f = def ['a] { a }\nf ('b : 1)", err: "f has no parameter b"},
+		{name: "keyword twice", src: "'f = def ['a] { a }\nf 1 ('a : 2)", err: "f got multiple values for parameter a"},
+		{name: "duplicate parameter", src: "def ['a 'a] { a }", err: "duplicate parameter a"},
+		{name: "required after optional", src: "def [['a 1] 'b] { a }", err: "required parameter b can't follow optional parameters"},
+		{name: "rest not last", src: "def [(rest 'xs) 'a] { a }", err: "rest parameter xs must be the last parameter"},
+	})
+}
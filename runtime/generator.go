@@ -0,0 +1,259 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/erikfastermann/quinn/value"
+)
+
+var (
+	errYieldOutsideGenerator = errors.New("yield outside of a generator")
+	errSignalInGenerator     = errors.New("return, break or continue can't leave a generator block")
+)
+
+// generatorClosed unwinds the body of a closed generator
+// from the yield it is suspended in.
+type generatorClosed struct{}
+
+func (generatorClosed) Error() string {
+	return "generator closed"
+}
+
+// newGenerator returns an iterable: each call starts
+// a new generator running body. A generator should be closed
+// if it isn't exhausted, generators which are still suspended
+// when the run that started them ends are closed then.
+func newGenerator(body Block) (value.Value, error) {
+	return newBlockMust(func(_ Unit) (value.Value, error) {
+		return &generator{body: body}, nil
+	}), nil
+}
+
+type genResult struct {
+	v        value.Value
+	err      error
+	finished bool
+}
+
+// generator runs its body on its own goroutine, which is suspended
+// until the generator is called for the next value.
+type generator struct {
+	body Block
+
+	mu       sync.Mutex
+	started  bool
+	finished bool
+	resume   chan bool
+	results  chan genResult
+	// run is the run the generator was started in
+	run *run
+	// stopped is closed when run ends
+	stopped  chan struct{}
+	stopOnce sync.Once
+	// exited is closed once the goroutine running the body returned
+	exited chan struct{}
+	// cancel cancels the context of the body
+	cancel context.CancelFunc
+	// suspended is set while the body waits in yield
+	// or for its start
+	suspendedMu sync.Mutex
+	suspended   bool
+
+	// closed is set once the body has to unwind,
+	// it is only used by the thread running the body
+	closed bool
+}
+
+func (*generator) Tag() value.Tag {
+	return tagBlock
+}
+
+func (g *generator) runWithEnv(t *thread, env *Environment, args ...value.Value) (*Environment, value.Value, error) {
+	v, err := g.runWithoutEnv(t, args...)
+	return env, v, err
+}
+
+// runWithoutEnv returns the next value yielded by the body
+// or stop after the body finished.
+func (g *generator) runWithoutEnv(t *thread, args ...value.Value) (value.Value, error) {
+	if len(args) > 1 || (len(args) == 1 && args[0] != unit) {
+		return nil, errors.New("generators take no arguments")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.finished {
+		return stop, nil
+	}
+	if !g.started {
+		g.start(t)
+	}
+	return g.send(t, true)
+}
+
+// start runs the body as part of the run of t.
+func (g *generator) start(t *thread) {
+	g.started = true
+	g.resume = make(chan bool)
+	g.results = make(chan genResult)
+	g.run = t.run
+	g.stopped = make(chan struct{})
+	g.exited = make(chan struct{})
+	child := t.spawn()
+	child.depth = t.depth
+	child.gen = g
+	child.ctx, g.cancel = context.WithCancel(t.ctx)
+	child.done = child.ctx.Done()
+	g.suspended = true
+	g.run.addGenerator(g)
+	go func() {
+		defer close(g.exited)
+		defer g.cancel()
+		var resume bool
+		select {
+		case resume = <-g.resume:
+		case <-g.stopped:
+			return
+		}
+		g.setSuspended(false)
+		var err error
+		if resume {
+			_, err = g.body.runWithoutEnv(child)
+			if errors.As(err, &generatorClosed{}) {
+				err = nil
+			} else if err != nil && isSignal(err) {
+				err = replaceCause(err, errSignalInGenerator)
+			}
+		}
+		select {
+		case g.results <- genResult{err: err, finished: true}:
+		case <-g.stopped:
+		}
+	}()
+}
+
+// stop unwinds the body and waits until it returned.
+// If the body isn't suspended in yield, its context is canceled,
+// so it doesn't stay blocked in other operations.
+func (g *generator) stop() {
+	g.stopOnce.Do(func() {
+		close(g.stopped)
+	})
+	g.suspendedMu.Lock()
+	if !g.suspended {
+		g.cancel()
+	}
+	g.suspendedMu.Unlock()
+	<-g.exited
+}
+
+func (g *generator) setSuspended(suspended bool) {
+	g.suspendedMu.Lock()
+	defer g.suspendedMu.Unlock()
+	g.suspended = suspended
+}
+
+// send resumes the body and waits until it yields or finishes.
+func (g *generator) send(t *thread, resume bool) (value.Value, error) {
+	select {
+	case g.resume <- resume:
+	case <-g.stopped:
+		g.finished = true
+		return stop, nil
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+	select {
+	case r := <-g.results:
+		if !r.finished {
+			return r.v, nil
+		}
+		g.finished = true
+		g.run.removeGenerator(g)
+		if r.err != nil {
+			return nil, r.err
+		}
+		return stop, nil
+	case <-g.stopped:
+		g.finished = true
+		return stop, nil
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+}
+
+// close unwinds the body from the yield it is suspended in,
+// running its finally blocks.
+func (g *generator) close(t *thread) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.finished || !g.started {
+		g.finished = true
+		return nil
+	}
+	_, err := g.send(t, false)
+	return err
+}
+
+func yield(t *thread, v value.Value) (value.Value, error) {
+	g := t.gen
+	if g == nil {
+		return nil, errYieldOutsideGenerator
+	}
+	if g.closed {
+		return nil, generatorClosed{}
+	}
+	g.setSuspended(true)
+	defer g.setSuspended(false)
+	select {
+	case g.results <- genResult{v: v}:
+	case <-g.stopped:
+		g.closed = true
+		return nil, generatorClosed{}
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+	select {
+	case resume := <-g.resume:
+		if !resume {
+			g.closed = true
+			return nil, generatorClosed{}
+		}
+		return unit, nil
+	case <-g.stopped:
+		g.closed = true
+		return nil, generatorClosed{}
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+}
+
+// closeValue closes channels and generators,
+// other blocks have nothing to clean up.
+func closeValue(t *thread, v value.Value) (value.Value, error) {
+	switch v := v.(type) {
+	case *Chan:
+		return closeChan(v)
+	case *generator:
+		if err := v.close(t); err != nil {
+			return nil, err
+		}
+		return unit, nil
+	case Block:
+		return unit, nil
+	default:
+		return nil, fmt.Errorf("can't close %s", valueString(t, v))
+	}
+}
+
+// finally runs cleanup after b, even if b failed.
+// An error of cleanup is only returned if b succeeded.
+func finally(t *thread, b, cleanup Block) (value.Value, error) {
+	v, err := b.runWithoutEnv(t, unit)
+	if _, cleanupErr := cleanup.runWithoutEnv(t, unit); cleanupErr != nil && err == nil {
+		return nil, cleanupErr
+	}
+	return v, err
+}
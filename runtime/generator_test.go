@@ -0,0 +1,65 @@
+package runtime
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGeneratorClosedWhenRunEnds(t *testing.T) {
+	var out strings.Builder
+	in := NewInterpreter(Options{Stdout: &out})
+	before := runtime.NumGoroutine()
+	mustEval(t, in, `'numbers = generator {
+	finally {
+		yield 1
+		yield 2
+	} {
+		println 0
+	}
+}
+'next = numbers ()
+println (next ())`)
+	if got := out.String(); got != "1\n0\n" {
+		t.Fatalf("got output %q, want the finally block to run", got)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutine(s) leaked", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	v := mustEval(t, in, "next ()")
+	if v != stop {
+		t.Fatalf("generator of an ended run returned %v", v)
+	}
+}
+
+func TestGeneratorBlockedWhenRunEnds(t *testing.T) {
+	in := NewInterpreter(Options{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := in.EvalString(`'c = chan 0
+'waiting = generator {
+	yield 1
+	yield (recv c)
+}
+'next = waiting ()
+next ()
+spawn { next () }
+sleep (1 / 50)`)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't end with a generator blocked in recv")
+	}
+}
@@ -0,0 +1,311 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math/bits"
+	"sort"
+
+	"github.com/erikfastermann/quinn/number"
+	"github.com/erikfastermann/quinn/value"
+)
+
+const (
+	hamtBits     = 5
+	hamtMask     = 1<<hamtBits - 1
+	hamtMaxShift = 32
+)
+
+type hamtEntry struct {
+	hash  uint32
+	key   value.Value
+	value value.Value
+	seq   uint64 // insertion order
+}
+
+type hamtSlot struct {
+	entry *hamtEntry
+	node  *hamtNode
+}
+
+// hamtNode is a node of a persistent hash array mapped trie.
+// Nodes are never modified after they have been created.
+type hamtNode struct {
+	bitmap uint32
+	slots  []hamtSlot
+
+	// collisions is only used once all bits of the hash are consumed
+	collisions []*hamtEntry
+}
+
+func (n *hamtNode) get(hash uint32, shift uint, key value.Value) (*hamtEntry, bool) {
+	for n != nil {
+		if shift >= hamtMaxShift {
+			for _, e := range n.collisions {
+				if keyEq(e.key, key) {
+					return e, true
+				}
+			}
+			return nil, false
+		}
+
+		bit := uint32(1) << ((hash >> shift) & hamtMask)
+		if n.bitmap&bit == 0 {
+			return nil, false
+		}
+		slot := n.slots[bits.OnesCount32(n.bitmap&(bit-1))]
+		if slot.entry != nil {
+			if slot.entry.hash == hash && keyEq(slot.entry.key, key) {
+				return slot.entry, true
+			}
+			return nil, false
+		}
+		n = slot.node
+		shift += hamtBits
+	}
+	return nil, false
+}
+
+// insert returns the old entry if the key was already present.
+// The new entry takes over the insertion order of the old one in that case.
+func (n *hamtNode) insert(e *hamtEntry, shift uint) (*hamtNode, *hamtEntry) {
+	if n == nil {
+		n = &hamtNode{}
+	}
+
+	if shift >= hamtMaxShift {
+		next := &hamtNode{collisions: make([]*hamtEntry, len(n.collisions), len(n.collisions)+1)}
+		copy(next.collisions, n.collisions)
+		for i, old := range next.collisions {
+			if keyEq(old.key, e.key) {
+				next.collisions[i] = &hamtEntry{e.hash, e.key, e.value, old.seq}
+				return next, old
+			}
+		}
+		next.collisions = append(next.collisions, e)
+		return next, nil
+	}
+
+	bit := uint32(1) << ((e.hash >> shift) & hamtMask)
+	idx := bits.OnesCount32(n.bitmap & (bit - 1))
+	if n.bitmap&bit == 0 {
+		next := &hamtNode{bitmap: n.bitmap | bit, slots: make([]hamtSlot, len(n.slots)+1)}
+		copy(next.slots, n.slots[:idx])
+		next.slots[idx] = hamtSlot{entry: e}
+		copy(next.slots[idx+1:], n.slots[idx:])
+		return next, nil
+	}
+
+	var (
+		slot = n.slots[idx]
+		old  *hamtEntry
+	)
+	switch {
+	case slot.node != nil:
+		var child *hamtNode
+		child, old = slot.node.insert(e, shift+hamtBits)
+		slot = hamtSlot{node: child}
+	case slot.entry.hash == e.hash && keyEq(slot.entry.key, e.key):
+		old = slot.entry
+		slot = hamtSlot{entry: &hamtEntry{e.hash, e.key, e.value, old.seq}}
+	default:
+		child, _ := (*hamtNode)(nil).insert(slot.entry, shift+hamtBits)
+		child, _ = child.insert(e, shift+hamtBits)
+		slot = hamtSlot{node: child}
+	}
+
+	next := &hamtNode{bitmap: n.bitmap, slots: make([]hamtSlot, len(n.slots))}
+	copy(next.slots, n.slots)
+	next.slots[idx] = slot
+	return next, old
+}
+
+// delete returns nil as the node if it is empty after the deletion.
+func (n *hamtNode) delete(hash uint32, shift uint, key value.Value) (*hamtNode, bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	if shift >= hamtMaxShift {
+		for i, e := range n.collisions {
+			if keyEq(e.key, key) {
+				if len(n.collisions) == 1 {
+					return nil, true
+				}
+				next := &hamtNode{collisions: make([]*hamtEntry, 0, len(n.collisions)-1)}
+				next.collisions = append(next.collisions, n.collisions[:i]...)
+				next.collisions = append(next.collisions, n.collisions[i+1:]...)
+				return next, true
+			}
+		}
+		return n, false
+	}
+
+	bit := uint32(1) << ((hash >> shift) & hamtMask)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	idx := bits.OnesCount32(n.bitmap & (bit - 1))
+	slot := n.slots[idx]
+
+	var child *hamtNode
+	if slot.node != nil {
+		var ok bool
+		child, ok = slot.node.delete(hash, shift+hamtBits, key)
+		if !ok {
+			return n, false
+		}
+	} else if slot.entry.hash != hash || !keyEq(slot.entry.key, key) {
+		return n, false
+	}
+
+	if child != nil {
+		next := &hamtNode{bitmap: n.bitmap, slots: make([]hamtSlot, len(n.slots))}
+		copy(next.slots, n.slots)
+		next.slots[idx] = hamtSlot{node: child}
+		return next, true
+	}
+	if len(n.slots) == 1 {
+		return nil, true
+	}
+	next := &hamtNode{bitmap: n.bitmap &^ bit, slots: make([]hamtSlot, 0, len(n.slots)-1)}
+	next.slots = append(next.slots, n.slots[:idx]...)
+	next.slots = append(next.slots, n.slots[idx+1:]...)
+	return next, true
+}
+
+func (n *hamtNode) each(fn func(*hamtEntry)) {
+	if n == nil {
+		return
+	}
+	for _, e := range n.collisions {
+		fn(e)
+	}
+	for _, slot := range n.slots {
+		if slot.entry != nil {
+			fn(slot.entry)
+		} else {
+			slot.node.each(fn)
+		}
+	}
+}
+
+// hamt is a persistent hash map ordered by insertion.
+type hamt struct {
+	root    *hamtNode
+	len     int
+	nextSeq uint64
+}
+
+func (h hamt) get(t *thread, key value.Value) (*hamtEntry, bool, error) {
+	hash, err := hashKey(t, key)
+	if err != nil {
+		return nil, false, err
+	}
+	e, ok := h.root.get(hash, 0, key)
+	return e, ok, nil
+}
+
+func (h hamt) insert(t *thread, key, v value.Value) (hamt, error) {
+	hash, err := hashKey(t, key)
+	if err != nil {
+		return hamt{}, err
+	}
+	root, old := h.root.insert(&hamtEntry{hash, key, v, h.nextSeq}, 0)
+	if old != nil {
+		return hamt{root, h.len, h.nextSeq}, nil
+	}
+	return hamt{root, h.len + 1, h.nextSeq + 1}, nil
+}
+
+func (h hamt) delete(t *thread, key value.Value) (hamt, error) {
+	hash, err := hashKey(t, key)
+	if err != nil {
+		return hamt{}, err
+	}
+	root, ok := h.root.delete(hash, 0, key)
+	if !ok {
+		return h, nil
+	}
+	return hamt{root, h.len - 1, h.nextSeq}, nil
+}
+
+func (h hamt) entries() []*hamtEntry {
+	entries := make([]*hamtEntry, 0, h.len)
+	h.root.each(func(e *hamtEntry) {
+		entries = append(entries, e)
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+	return entries
+}
+
+func hashKey(t *thread, key value.Value) (uint32, error) {
+	h := fnv.New32a()
+	if err := writeKey(t, h, key); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+func writeKey(t *thread, h hash.Hash32, key value.Value) error {
+	var buf [binary.MaxVarintLen64]byte
+	writeString := func(kind byte, s string) {
+		h.Write([]byte{kind})
+		h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(s)))])
+		h.Write([]byte(s))
+	}
+
+	switch k := key.(type) {
+	case number.Number:
+		writeString('n', k.String())
+	case String:
+		writeString('s', string(k))
+	case Atom:
+		writeString('a', string(k))
+	case Bool:
+		if k.AsBool() {
+			h.Write([]byte{'t'})
+		} else {
+			h.Write([]byte{'f'})
+		}
+	case value.Tag:
+		h.Write([]byte{'g'})
+		h.Write(buf[:binary.PutUvarint(buf[:], k.Hash())])
+	case List:
+		h.Write([]byte{'l'})
+		h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(k.data)))])
+		for _, v := range k.data {
+			if err := writeKey(t, h, v); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s can't be used as a key", valueString(t, key))
+	}
+	return nil
+}
+
+// keyEq assumes both values were accepted by hashKey.
+func keyEq(x, y value.Value) bool {
+	switch x := x.(type) {
+	case number.Number:
+		return x.Eq(y)
+	case List:
+		y, ok := y.(List)
+		if !ok || len(x.data) != len(y.data) {
+			return false
+		}
+		for i := range x.data {
+			if !keyEq(x.data[i], y.data[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return x == y
+	}
+}
@@ -0,0 +1,135 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/erikfastermann/quinn/number"
+	"github.com/erikfastermann/quinn/value"
+)
+
+func testThread() *thread {
+	return newThread(newInstance(newStreams(Options{})), Options{})
+}
+
+func TestHamtInsertGetDelete(t *testing.T) {
+	th := testThread()
+	var h hamt
+	const n = 2000
+	for i := 0; i < n; i++ {
+		var err error
+		h, err = h.insert(th, number.FromInt(i), String("v"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if h.len != n {
+		t.Fatalf("len = %d, want %d", h.len, n)
+	}
+
+	old := h
+	h, err := h.insert(th, number.FromInt(7), String("replaced"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.len != n {
+		t.Fatalf("len after replace = %d, want %d", h.len, n)
+	}
+	if e, _, _ := old.get(th, number.FromInt(7)); e.value != String("v") {
+		t.Fatalf("old version changed to %v", e.value)
+	}
+	if e, _, _ := h.get(th, number.FromInt(7)); e.value != String("replaced") {
+		t.Fatalf("got %v, want replaced", e.value)
+	}
+
+	for i := 0; i < n; i += 2 {
+		if h, err = h.delete(th, number.FromInt(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if h.len != n/2 {
+		t.Fatalf("len after delete = %d, want %d", h.len, n/2)
+	}
+	for i := 0; i < n; i++ {
+		_, ok, err := h.get(th, number.FromInt(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != (i%2 == 1) {
+			t.Fatalf("get(%d) = %v", i, ok)
+		}
+	}
+	if _, ok, _ := old.get(th, number.FromInt(0)); !ok {
+		t.Fatal("delete changed an old version")
+	}
+
+	for i := 1; i < n; i += 2 {
+		h, _ = h.delete(th, number.FromInt(i))
+	}
+	if h.len != 0 || h.root != nil {
+		t.Fatalf("not empty after deleting everything: len %d", h.len)
+	}
+}
+
+func TestHamtEntriesInInsertionOrder(t *testing.T) {
+	th := testThread()
+	var h hamt
+	keys := []value.Value{String("c"), Atom("a"), number.FromInt(3), trueValue, String("b")}
+	for _, k := range keys {
+		h, _ = h.insert(th, k, unit)
+	}
+	h, _ = h.insert(th, Atom("a"), trueValue)
+	for i, e := range h.entries() {
+		if !keyEq(e.key, keys[i]) {
+			t.Fatalf("entry %d is %v, want %v", i, e.key, keys[i])
+		}
+	}
+}
+
+func TestHamtCollisions(t *testing.T) {
+	const hash = 0xdeadbeef
+	keys := []value.Value{String("x"), String("y"), String("z")}
+	var root *hamtNode
+	for i, k := range keys {
+		var old *hamtEntry
+		root, old = root.insert(&hamtEntry{hash, k, number.FromInt(i), uint64(i)}, 0)
+		if old != nil {
+			t.Fatalf("insert %v replaced %v", k, old.key)
+		}
+	}
+	for i, k := range keys {
+		e, ok := root.get(hash, 0, k)
+		if !ok || e.value.(number.Number).Cmp(number.FromInt(i)) != 0 {
+			t.Fatalf("get %v = %v, %v", k, e, ok)
+		}
+	}
+	if _, ok := root.get(hash, 0, String("w")); ok {
+		t.Fatal("found missing key with colliding hash")
+	}
+
+	replaced, old := root.insert(&hamtEntry{hash, String("y"), unit, 9}, 0)
+	if old == nil || old.seq != 1 {
+		t.Fatalf("replace returned %v", old)
+	}
+	if e, _ := replaced.get(hash, 0, String("y")); e.value != unit || e.seq != 1 {
+		t.Fatalf("replaced entry is %v", e)
+	}
+
+	root, ok := root.delete(hash, 0, String("y"))
+	if !ok {
+		t.Fatal("delete failed")
+	}
+	if _, ok := root.get(hash, 0, String("y")); ok {
+		t.Fatal("deleted key still present")
+	}
+	if _, ok := root.get(hash, 0, String("z")); !ok {
+		t.Fatal("delete removed another colliding key")
+	}
+	if _, ok := root.delete(hash, 0, String("w")); ok {
+		t.Fatal("deleted missing key")
+	}
+	root, _ = root.delete(hash, 0, String("x"))
+	root, _ = root.delete(hash, 0, String("z"))
+	if root != nil {
+		t.Fatal("not empty after deleting every colliding key")
+	}
+}
@@ -0,0 +1,118 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/erikfastermann/quinn/value"
+)
+
+// instance is the state shared by the threads of an interpreter.
+type instance struct {
+	sources *sources
+	types   *types
+	// streams are used when values are rendered outside of a run
+	streams *streams
+}
+
+func newInstance(streams *streams) *instance {
+	return &instance{
+		sources: &sources{lines: make(map[string][]string)},
+		types:   &types{attrs: make(map[value.Tag]func(value.Value, value.Tag) (value.Value, bool))},
+		streams: streams,
+	}
+}
+
+// defaultInstance is used by Run and the package level registration functions.
+var defaultInstance = newInstance(newStreams(Options{}))
+
+// renderThread returns a thread for rendering values
+// outside of a run, e.g. in error messages.
+func (inst *instance) renderThread() *thread {
+	t := &thread{
+		maxDepth: DefaultMaxCallDepth,
+		limits:   &limits{},
+		streams:  inst.streams,
+		run:      &run{},
+		inst:     inst,
+	}
+	t.setContext(context.Background())
+	return t
+}
+
+// sources holds the lines of the code run, used for tracebacks.
+type sources struct {
+	mu    sync.Mutex
+	lines map[string][]string
+}
+
+func (s *sources) register(path string, lines []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.lines[path]; ok {
+		return fmt.Errorf("duplicate path %q", path)
+	}
+	s.lines[path] = lines
+	return nil
+}
+
+func (s *sources) replace(path string, lines []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines[path] = lines
+}
+
+func (s *sources) unload(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.lines, path)
+}
+
+func (s *sources) line(path string, line int) (string, error) {
+	line--
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines, ok := s.lines[path]
+	if !ok {
+		return "", fmt.Errorf("unknown path %s", path)
+	}
+	if line < 0 || line >= len(lines) {
+		return "", fmt.Errorf(
+			"line index out of bounds (%d with length %d)",
+			line,
+			len(lines),
+		)
+	}
+	return lines[line], nil
+}
+
+// types holds the attributes of registered types,
+// the builtin types in tagValues are shared by all instances.
+type types struct {
+	mu    sync.RWMutex
+	attrs map[value.Tag]func(value.Value, value.Tag) (value.Value, bool)
+}
+
+func (ts *types) get(tag value.Tag) (func(value.Value, value.Tag) (value.Value, bool), bool) {
+	if attrs, ok := tagValues[tag]; ok {
+		return attrs, true
+	}
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	attrs, ok := ts.attrs[tag]
+	return attrs, ok
+}
+
+func (ts *types) register(tag value.Tag, attrs func(value.Value, value.Tag) (value.Value, bool)) error {
+	if _, ok := tagValues[tag]; ok {
+		return fmt.Errorf("type is already registered")
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if _, ok := ts.attrs[tag]; ok {
+		return fmt.Errorf("type is already registered")
+	}
+	ts.attrs[tag] = attrs
+	return nil
+}
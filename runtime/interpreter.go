@@ -0,0 +1,191 @@
+package runtime
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/erikfastermann/quinn/check"
+	"github.com/erikfastermann/quinn/parser"
+	"github.com/erikfastermann/quinn/value"
+)
+
+// Interpreter evaluates Quinn code. Names defined by earlier
+// evaluations and by Define are visible to later evaluations.
+// Each evaluation runs in its own scope and can shadow earlier names.
+type Interpreter struct {
+	opts Options
+	inst *instance
+
+	mu      sync.Mutex
+	env     *Environment
+	strings int
+}
+
+func NewInterpreter(opts Options) *Interpreter {
+	inst := newInstance(newStreams(opts))
+	return &Interpreter{
+		opts: opts,
+		inst: inst,
+		env:  newBuiltinEnv(inst),
+	}
+}
+
+func (in *Interpreter) newThread(ctx context.Context) *thread {
+	t := newThread(in.inst, in.opts)
+	t.setContext(ctx)
+	t.streams = in.inst.streams
+	return t
+}
+
+// EvalString evaluates src and returns the value of its last statement.
+func (in *Interpreter) EvalString(src string) (value.Value, error) {
+	return in.EvalStringContext(context.Background(), src)
+}
+
+// EvalStringContext is like EvalString, but stops when ctx is done.
+func (in *Interpreter) EvalStringContext(ctx context.Context, src string) (value.Value, error) {
+	in.mu.Lock()
+	in.strings++
+	path := fmt.Sprintf("<string %d>", in.strings)
+	in.mu.Unlock()
+	return in.eval(ctx, path, strings.NewReader(src), strings.Split(src, "\n"))
+}
+
+// EvalFile evaluates the file at path and returns the value of its last statement.
+func (in *Interpreter) EvalFile(path string) (value.Value, error) {
+	return in.EvalFileContext(context.Background(), path)
+}
+
+// EvalFileContext is like EvalFile, but stops when ctx is done.
+func (in *Interpreter) EvalFileContext(ctx context.Context, path string) (value.Value, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	src := string(data)
+	return in.eval(ctx, path, strings.NewReader(src), strings.Split(src, "\n"))
+}
+
+func (in *Interpreter) eval(ctx context.Context, path string, r io.Reader, lines []string) (value.Value, error) {
+	b, err := parser.Parse(parser.NewLexer(path, bufio.NewReader(r)))
+	if err != nil {
+		return nil, err
+	}
+	if in.opts.Warnings != nil {
+		for _, w := range check.Check(b) {
+			fmt.Fprintln(in.opts.Warnings, w)
+		}
+	}
+	in.inst.sources.replace(path, lines)
+
+	in.mu.Lock()
+	env := in.env
+	in.mu.Unlock()
+	t := in.newThread(ctx)
+	env, v, err := runCode(t, basicBlock{env: env, code: b}, nil)
+	if finishErr := t.finish(); err == nil {
+		err = finishErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	in.mu.Lock()
+	in.env = env
+	in.mu.Unlock()
+	return v, nil
+}
+
+// Define binds name to v. If v is not a Value,
+// it must be a Go func which is converted with NewBlock.
+func (in *Interpreter) Define(name string, v interface{}) error {
+	val, ok := v.(value.Value)
+	if !ok {
+		b, err := NewBlock(v)
+		if err != nil {
+			return err
+		}
+		val = b
+	}
+	if b, ok := val.(namedBlock); ok && b.blockName() == "" {
+		val = b.withName(name)
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	env, ok := in.env.insert(Atom(name), val)
+	if !ok {
+		return fmt.Errorf("%s already exists in this scope", name)
+	}
+	in.env = env
+	return nil
+}
+
+// RegisterType is like the package level RegisterType,
+// but the type is only known to this interpreter.
+func (in *Interpreter) RegisterType(tag value.Tag, attrs map[value.Tag]interface{}) error {
+	return in.inst.registerType(tag, attrs)
+}
+
+// Unload forgets the source of path, tracebacks
+// pointing into it no longer show the code.
+func (in *Interpreter) Unload(path string) {
+	in.inst.sources.unload(path)
+}
+
+func (in *Interpreter) Lookup(name string) (value.Value, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.env.get(Atom(name))
+}
+
+// Encode is like the package level Encode, but blocks created
+// from funcs run with the types and streams of this interpreter.
+func (in *Interpreter) Encode(v interface{}) (value.Value, error) {
+	t := in.newThread(context.Background())
+	defer t.finish()
+	return encode(t, reflect.ValueOf(v))
+}
+
+// Decode is like the package level Decode, but funcs created
+// from blocks run with the types and streams of this interpreter.
+func (in *Interpreter) Decode(v value.Value, ptr interface{}) error {
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("decode needs a non nil pointer")
+	}
+	t := in.newThread(context.Background())
+	defer t.finish()
+	t.callThread = func() *thread {
+		return in.newThread(context.Background())
+	}
+	return decode(t, v, rv.Elem())
+}
+
+// Call calls the block b with args.
+func (in *Interpreter) Call(b value.Value, args ...value.Value) (value.Value, error) {
+	return in.CallContext(context.Background(), b, args...)
+}
+
+// CallContext is like Call, but stops when ctx is done.
+func (in *Interpreter) CallContext(ctx context.Context, b value.Value, args ...value.Value) (value.Value, error) {
+	block, ok := b.(Block)
+	if !ok {
+		return nil, errors.New("can't call a value which is not a block")
+	}
+	in.mu.Lock()
+	env := in.env
+	in.mu.Unlock()
+	t := in.newThread(ctx)
+	_, v, err := block.runWithEnv(t, env, args...)
+	if finishErr := t.finish(); err == nil {
+		err = finishErr
+	}
+	return v, err
+}
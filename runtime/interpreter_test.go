@@ -0,0 +1,167 @@
+package runtime
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/erikfastermann/quinn/number"
+	"github.com/erikfastermann/quinn/value"
+)
+
+func TestInterpreter(t *testing.T) {
+	in := NewInterpreter(Options{})
+	if err := in.Define("double", func(n value.Value) (value.Value, error) {
+		return NewList(n, n), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Define("greeting", NewString("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"last statement", "1\n2", "2"},
+		{"define", "'x = 3\nx", "3"},
+		{"earlier definition", "x + 1", "4"},
+		{"shadowing", "'x = 5\nx", "5"},
+		{"go func", "double x", "[5 5]"},
+		{"go value", "greeting", `"hello"`},
+		{"function", "'inc = (['n] -> { n + 1 })\ninc", "<block>"},
+	}
+	for _, c := range cases {
+		v, err := in.EvalString(c.src)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := valueString(newThread(defaultInstance, Options{}), v); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+
+	inc, ok := in.Lookup("inc")
+	if !ok {
+		t.Fatal("inc not found")
+	}
+	v, err := in.Call(inc, number.FromInt(41))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := valueString(newThread(defaultInstance, Options{}), v); got != "42" {
+		t.Errorf("call: got %s", got)
+	}
+	if _, ok := in.Lookup("missing"); ok {
+		t.Error("lookup of missing name succeeded")
+	}
+}
+
+func TestInterpreterErrors(t *testing.T) {
+	in := NewInterpreter(Options{})
+	if err := in.Define("a", NewUnit()); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Define("a", NewUnit()); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("define twice: got %v", err)
+	}
+	if err := in.Define("b", func() {}); err == nil {
+		t.Error("define of invalid func succeeded")
+	}
+	if _, err := in.Call(NewUnit()); err == nil || !strings.Contains(err.Error(), "not a block") {
+		t.Errorf("call of unit: got %v", err)
+	}
+	if _, err := in.EvalString("'c = 1\nunknownName ()"); err == nil {
+		t.Error("eval of unknown name succeeded")
+	}
+	if _, ok := in.Lookup("c"); ok {
+		t.Error("names of failed evaluations are kept")
+	}
+	if _, err := in.EvalString("("); err == nil {
+		t.Error("eval of invalid syntax succeeded")
+	}
+}
+
+func TestInterpreterWarnings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "warnings.qn")
+	if err := os.WriteFile(path, []byte("match 1 ['_ { 1 } 2 { 2 }]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var warnings bytes.Buffer
+	in := NewInterpreter(Options{Warnings: &warnings})
+	if _, err := in.EvalFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if warnings.Len() == 0 {
+		t.Error("expected warnings")
+	}
+}
+
+func TestInterpreterTypes(t *testing.T) {
+	tag := value.NewTag()
+	in := NewInterpreter(Options{})
+	err := in.RegisterType(tag, map[value.Tag]interface{}{
+		EqTag(): func(x, y value.Value) (value.Value, error) {
+			return trueValue, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := in.RegisterType(tag, nil); err == nil {
+		t.Error("registered type twice")
+	}
+	if err := in.Define("x", testOpaque{tag}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := in.EvalString("x == 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != trueValue {
+		t.Errorf("eq: got %v", v)
+	}
+
+	other := NewInterpreter(Options{})
+	if err := other.Define("x", testOpaque{tag}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.EvalString("x == 1"); err == nil {
+		t.Error("type is known to other interpreter")
+	}
+}
+
+type testOpaque struct{ tag value.Tag }
+
+func (o testOpaque) Tag() value.Tag {
+	return o.tag
+}
+
+func TestInterpreterRendersOwnTypes(t *testing.T) {
+	tag := value.NewTag()
+	in := NewInterpreter(Options{})
+	err := in.RegisterType(tag, map[value.Tag]interface{}{
+		StringerTag(): func(_ value.Value) (value.Value, error) {
+			return NewString("own"), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Define("x", testOpaque{tag}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = in.EvalString("raise x")
+	if err == nil || !strings.Contains(err.Error(), "own") {
+		t.Errorf("raise: got %v", err)
+	}
+	if env := in.env.String(); !strings.Contains(env, "[x own]") {
+		t.Errorf("environment: got %s", env)
+	}
+}
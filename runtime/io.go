@@ -0,0 +1,150 @@
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/erikfastermann/quinn/value"
+)
+
+// streams are the standard streams used by the I/O builtins,
+// output to stdout is buffered until it is flushed.
+type streams struct {
+	// mu guards the output streams
+	mu     sync.Mutex
+	stdout *bufio.Writer
+	stderr io.Writer
+
+	// reader is held by the thread reading from stdin
+	reader chan struct{}
+	stdin  *bufio.Reader
+	// reading is set while a line is read in the background,
+	// the line is sent on lines, even if the reader gave up
+	reading bool
+	lines   chan lineResult
+}
+
+type lineResult struct {
+	line string
+	err  error
+}
+
+func newStreams(opts Options) *streams {
+	s := &streams{
+		stdout: bufio.NewWriter(opts.Stdout),
+		stderr: opts.Stderr,
+		reader: make(chan struct{}, 1),
+		stdin:  bufio.NewReader(opts.Stdin),
+		lines:  make(chan lineResult, 1),
+	}
+	if opts.Stdin == nil {
+		s.stdin = bufio.NewReader(os.Stdin)
+	}
+	if opts.Stdout == nil {
+		s.stdout = bufio.NewWriter(os.Stdout)
+	}
+	if opts.Stderr == nil {
+		s.stderr = os.Stderr
+	}
+	return s
+}
+
+func (s *streams) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stdout.Flush()
+}
+
+func joinValues(t *thread, args []value.Value) string {
+	strs := make([]string, len(args))
+	for i, v := range args {
+		strs[i] = valueString(t, v)
+	}
+	return strings.Join(strs, " ")
+}
+
+func print_(t *thread, args ...value.Value) (value.Value, error) {
+	str := joinValues(t, args)
+	t.streams.mu.Lock()
+	defer t.streams.mu.Unlock()
+	if _, err := t.streams.stdout.WriteString(str); err != nil {
+		return nil, err
+	}
+	return unit, nil
+}
+
+func println_(t *thread, args ...value.Value) (value.Value, error) {
+	str := joinValues(t, args)
+	t.streams.mu.Lock()
+	defer t.streams.mu.Unlock()
+	if _, err := fmt.Fprintln(t.streams.stdout, str); err != nil {
+		return nil, err
+	}
+	return unit, nil
+}
+
+// eprintln flushes stdout first, so the output stays in order
+// if both streams go to the same terminal.
+func eprintln(t *thread, args ...value.Value) (value.Value, error) {
+	str := joinValues(t, args)
+	t.streams.mu.Lock()
+	defer t.streams.mu.Unlock()
+	if err := t.streams.stdout.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintln(t.streams.stderr, str); err != nil {
+		return nil, err
+	}
+	return unit, nil
+}
+
+func flush(t *thread, _ Unit) (value.Value, error) {
+	if err := t.streams.flush(); err != nil {
+		return nil, err
+	}
+	return unit, nil
+}
+
+// readLine returns the next line of stdin without the line ending,
+// or unit at the end of the input. If the context is done while
+// waiting, the line is kept for the next call.
+func readLine(t *thread, _ Unit) (value.Value, error) {
+	s := t.streams
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+	select {
+	case s.reader <- struct{}{}:
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+	defer func() { <-s.reader }()
+
+	if !s.reading {
+		s.reading = true
+		go func() {
+			line, err := s.stdin.ReadString('\n')
+			s.lines <- lineResult{line, err}
+		}()
+	}
+	var r lineResult
+	select {
+	case r = <-s.lines:
+		s.reading = false
+	case <-t.done:
+		return nil, t.ctxErr()
+	}
+
+	if r.err == io.EOF && r.line == "" {
+		return unit, nil
+	}
+	if r.err != nil && r.err != io.EOF {
+		return nil, r.err
+	}
+	line := strings.TrimSuffix(r.line, "\n")
+	return String(strings.TrimSuffix(line, "\r")), nil
+}
@@ -0,0 +1,102 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/quinn/value"
+)
+
+func TestStreams(t *testing.T) {
+	cases := []struct {
+		name   string
+		src    string
+		stdin  string
+		stdout string
+		stderr string
+		want   string
+	}{
+		{name: "println", src: "println 1 \"a\"\nprintln ()", stdout: "1 \"a\"\n()\n"},
+		{name: "print", src: "print 1\nprint 2", stdout: "12"},
+		{name: "eprintln", src: "eprintln 1 2", stderr: "1 2\n"},
+		{name: "read lines", src: "[(readLine ()) (readLine ())]", stdin: "a\r\nb", want: `["a" "b"]`},
+		{name: "end of input", src: "[(readLine ()) (readLine ())]", stdin: "a\n", want: `["a" ()]`},
+	}
+	for _, c := range cases {
+		var stdout, stderr bytes.Buffer
+		in := NewInterpreter(Options{
+			Stdin:  strings.NewReader(c.stdin),
+			Stdout: &stdout,
+			Stderr: &stderr,
+		})
+		v, err := in.EvalString(c.src)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if stdout.String() != c.stdout || stderr.String() != c.stderr {
+			t.Errorf("%s: got stdout %q and stderr %q", c.name, stdout.String(), stderr.String())
+		}
+		if c.want == "" {
+			continue
+		}
+		if got := valueString(newThread(defaultInstance, Options{}), v); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestStreamsBuffering(t *testing.T) {
+	var out bytes.Buffer
+	in := NewInterpreter(Options{Stdout: &out, Stderr: &out})
+	var seen []string
+	err := in.Define("seen", func(_ Unit) (value.Value, error) {
+		seen = append(seen, out.String())
+		return unit, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "println 1\nseen ()\nflush ()\nseen ()\nprintln 2\neprintln 3\nseen ()\nprintln 4"
+	if _, err := in.EvalString(src); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"", "1\n", "1\n2\n3\n"}
+	if strings.Join(seen, "|") != strings.Join(want, "|") {
+		t.Errorf("got output %q while running, want %q", seen, want)
+	}
+	if out.String() != "1\n2\n3\n4\n" {
+		t.Errorf("got output %q after running", out.String())
+	}
+}
+
+func TestReadLineStopsWithContext(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	var out strings.Builder
+	in := NewInterpreter(Options{Stdin: r, Stdout: &out})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := in.EvalStringContext(ctx, "'reader = spawn { readLine () }\nsleep (1 / 50)\nprintln 1\nawait reader")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if out.String() != "1\n" {
+		t.Fatalf("println blocked by readLine, got output %q", out.String())
+	}
+
+	go w.Write([]byte("hello\n"))
+	v, err := in.EvalString("readLine ()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != String("hello") {
+		t.Fatalf("got %v, want the line read after the cancellation", v)
+	}
+}
@@ -0,0 +1,105 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Limit is a resource limit enforced while running code.
+type Limit int
+
+const (
+	LimitSteps Limit = iota
+	LimitCallDepth
+	LimitAllocs
+	LimitDeadline
+)
+
+func (l Limit) String() string {
+	switch l {
+	case LimitSteps:
+		return "steps"
+	case LimitCallDepth:
+		return "call depth"
+	case LimitAllocs:
+		return "allocations"
+	case LimitDeadline:
+		return "deadline"
+	default:
+		panic(internal)
+	}
+}
+
+// LimitError is returned if running code exceeds one of the limits
+// configured in Options. It can't be caught by try or default.
+type LimitError struct {
+	Limit Limit
+	Max   int64 // the configured maximum, zero for LimitDeadline
+
+	// position of the code running when the limit was hit
+	Path         string
+	Line, Column int
+}
+
+func (e *LimitError) Error() string {
+	if e.Limit == LimitDeadline {
+		return "deadline exceeded"
+	}
+	return fmt.Sprintf("%s limit of %d exceeded", e.Limit, e.Max)
+}
+
+func isLimit(err error) bool {
+	var le *LimitError
+	return errors.As(err, &le)
+}
+
+// positionLimit records the position in err if it is
+// a LimitError which doesn't have one yet.
+func positionLimit(err error, path string, line, col int) {
+	if le, ok := err.(*LimitError); ok && le.Path == "" {
+		le.Path, le.Line, le.Column = path, line, col
+	}
+}
+
+// deadlineInterval is the number of steps between checks of the deadline.
+const deadlineInterval = 64
+
+// limits are shared by all threads of a run.
+type limits struct {
+	steps, maxSteps   int64
+	allocs, maxAllocs int64
+	deadline          time.Time
+}
+
+func newLimits(opts Options) *limits {
+	l := &limits{
+		maxSteps:  int64(opts.MaxSteps),
+		maxAllocs: int64(opts.MaxAllocs),
+	}
+	if opts.Timeout > 0 {
+		l.deadline = time.Now().Add(opts.Timeout)
+	}
+	return l
+}
+
+func (l *limits) step() error {
+	steps := atomic.AddInt64(&l.steps, 1)
+	if l.maxSteps > 0 && steps > l.maxSteps {
+		return &LimitError{Limit: LimitSteps, Max: l.maxSteps}
+	}
+	if !l.deadline.IsZero() && steps%deadlineInterval == 0 && time.Now().After(l.deadline) {
+		return &LimitError{Limit: LimitDeadline}
+	}
+	return nil
+}
+
+// alloc accounts for n newly allocated elements of a list, map or set.
+func (l *limits) alloc(n int) error {
+	allocs := atomic.AddInt64(&l.allocs, int64(n))
+	if l.maxAllocs > 0 && allocs > l.maxAllocs {
+		return &LimitError{Limit: LimitAllocs, Max: l.maxAllocs}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package runtime
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimeoutStopsBlockingBuiltins(t *testing.T) {
+	for _, src := range []string{
+		"sleep 2",
+		"recv (chan 0)",
+		"send (chan 0) 1",
+		"await (spawn { sleep 2 })",
+		"select [(onRecv (chan 0)) { 1 }]",
+	} {
+		in := NewInterpreter(Options{Timeout: 50 * time.Millisecond})
+		start := time.Now()
+		_, err := in.EvalString(src)
+		var le *LimitError
+		if !errors.As(err, &le) || le.Limit != LimitDeadline {
+			t.Errorf("%s: expected deadline error, got %v", src, err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s: took %s", src, d)
+		}
+	}
+}
+
+func TestCallbacksShareLimits(t *testing.T) {
+	in := NewInterpreter(Options{MaxSteps: 1000})
+	repeat, err := NewFuncBlock(func(n int, f func(int) int) {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Define("repeat", repeat); err != nil {
+		t.Fatal(err)
+	}
+	_, err = in.EvalString("repeat 10000 (['i] -> { i + 1 })")
+	var le *LimitError
+	if !errors.As(err, &le) || le.Limit != LimitSteps {
+		t.Fatalf("expected steps limit error, got %v", err)
+	}
+}
+
+func TestSetAlgebraAllocs(t *testing.T) {
+	for _, op := range []string{"union", "intersection", "difference"} {
+		in := NewInterpreter(Options{MaxAllocs: 100})
+		src := `'s = set [1 2 3 4 5]
+'one = set [1]
+'i = mut 0
+loop {
+	if ((load i) == 100) { break () }
+	i <- ((load i) + 1)
+	` + op + ` s one
+}`
+		_, err := in.EvalString(src)
+		var le *LimitError
+		if !errors.As(err, &le) || le.Limit != LimitAllocs {
+			t.Errorf("%s: expected allocation limit error, got %v", op, err)
+		}
+	}
+}
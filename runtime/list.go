@@ -14,24 +14,43 @@ type List struct {
 	data []value.Value
 }
 
+func NewList(values ...value.Value) List {
+	data := make([]value.Value, len(values))
+	copy(data, values)
+	return List{data}
+}
+
 func (List) Tag() value.Tag {
 	return tagList
 }
 
-func eqList(l List, v value.Value) (value.Value, error) {
+func (l List) Len() int {
+	return len(l.data)
+}
+
+func (l List) At(i int) value.Value {
+	return l.data[i]
+}
+
+// Values returns a copy of the elements of l.
+func (l List) Values() []value.Value {
+	return NewList(l.data...).data
+}
+
+func eqList(t *thread, l List, v value.Value) (value.Value, error) {
 	l2, ok := v.(List)
 	if !ok || len(l.data) != len(l2.data) {
 		return falseValue, nil
 	}
 	for i := range l.data {
 		// TODO: check cycle?
-		bV, err := eq(l.data[i], l2.data[i])
+		bV, err := eq(t, l.data[i], l2.data[i])
 		if err != nil {
 			return nil, err
 		}
 		b, ok := bV.(Bool)
 		if !ok {
-			return nil, fmt.Errorf("list equal: expected bool, got %s", valueString(bV))
+			return nil, fmt.Errorf("list equal: expected bool, got %s", valueString(t, bV))
 		}
 		if !b.AsBool() {
 			return falseValue, nil
@@ -42,7 +61,7 @@ func eqList(l List, v value.Value) (value.Value, error) {
 
 var stringEmptyList value.Value = String("[]")
 
-func stringerList(l List) (value.Value, error) {
+func stringerList(t *thread, l List) (value.Value, error) {
 	// TODO: check cycle?
 
 	if len(l.data) == 0 {
@@ -52,20 +71,17 @@ func stringerList(l List) (value.Value, error) {
 	var b strings.Builder
 	b.WriteString("[")
 	for _, v := range l.data[:len(l.data)-1] {
-		b.WriteString(valueString(v))
+		b.WriteString(valueString(t, v))
 		b.WriteString(" ")
 	}
-	b.WriteString(valueString(l.data[len(l.data)-1]))
+	b.WriteString(valueString(t, l.data[len(l.data)-1]))
 	b.WriteString("]")
 	return String(b.String()), nil
 }
 
 var noMatch value.Value = List{[]value.Value{falseValue, List{}}}
 
-func matcherList(matcher List, v value.Value) (value.Value, error) {
-	const errMatcherReturn = "expected matcher to return a pair of bool " +
-		"and list of unique atom and value pairs, got %s"
-
+func matcherList(t *thread, matcher List, v value.Value) (value.Value, error) {
 	candidate, ok := v.(List)
 	if !ok {
 		return noMatch, nil
@@ -76,36 +92,49 @@ func matcherList(matcher List, v value.Value) (value.Value, error) {
 
 	out := make([]value.Value, 0)
 	for i := range matcher.data {
-		m, c := matcher.data[i], candidate.data[i]
-		b, err := getAttributeBlock(m, tagMatcher)
-		if err != nil {
-			return nil, err
-		}
-		nextV, err := b.runWithoutEnv(m, c)
+		matched, pairs, err := runMatcher(t, matcher.data[i], candidate.data[i])
 		if err != nil {
 			return nil, err
 		}
-		next, ok := nextV.(List)
-		if !ok {
-			return nil, fmt.Errorf(errMatcherReturn, valueString(nextV))
-		}
-		if len(next.data) != 2 {
-			return nil, fmt.Errorf(errMatcherReturn, valueString(nextV))
-		}
-		matchedV, pairsV := next.data[0], next.data[1]
-		matched, ok := matchedV.(Bool)
-		if !ok {
-			return nil, fmt.Errorf(errMatcherReturn, valueString(nextV))
-		}
-		if !matched.AsBool() {
+		if !matched {
 			return noMatch, nil
 		}
-		pairs, ok := pairsV.(List)
-		if !ok {
-			return nil, fmt.Errorf(errMatcherReturn, valueString(nextV))
-		}
-		out = append(out, pairs.data...)
+		out = append(out, pairs...)
 	}
 
 	return List{[]value.Value{trueValue, List{out}}}, nil
 }
+
+func runMatcher(t *thread, m, v value.Value) (bool, []value.Value, error) {
+	const errMatcherReturn = "expected matcher to return a pair of bool " +
+		"and list of unique atom and value pairs, got %s"
+
+	b, err := getAttributeBlock(t, m, tagMatcher)
+	if err != nil {
+		return false, nil, err
+	}
+	nextV, err := b.runWithoutEnv(t, m, v)
+	if err != nil {
+		return false, nil, err
+	}
+	next, ok := nextV.(List)
+	if !ok {
+		return false, nil, fmt.Errorf(errMatcherReturn, valueString(t, nextV))
+	}
+	if len(next.data) != 2 {
+		return false, nil, fmt.Errorf(errMatcherReturn, valueString(t, nextV))
+	}
+	matchedV, pairsV := next.data[0], next.data[1]
+	matched, ok := matchedV.(Bool)
+	if !ok {
+		return false, nil, fmt.Errorf(errMatcherReturn, valueString(t, nextV))
+	}
+	if !matched.AsBool() {
+		return false, nil, nil
+	}
+	pairs, ok := pairsV.(List)
+	if !ok {
+		return false, nil, fmt.Errorf(errMatcherReturn, valueString(t, nextV))
+	}
+	return true, pairs.data, nil
+}
@@ -0,0 +1,139 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/erikfastermann/quinn/value"
+)
+
+var tagMap = value.NewTag()
+
+type Map struct {
+	h hamt
+}
+
+func (Map) Tag() value.Tag {
+	return tagMap
+}
+
+func (m Map) get(t *thread, k value.Value) (value.Value, bool, error) {
+	e, ok, err := m.h.get(t, k)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return e.value, true, nil
+}
+
+func (m Map) insert(t *thread, k, v value.Value) (Map, error) {
+	h, err := m.h.insert(t, k, v)
+	if err != nil {
+		return Map{}, err
+	}
+	return Map{h}, nil
+}
+
+func (m Map) delete(t *thread, k value.Value) (Map, error) {
+	h, err := m.h.delete(t, k)
+	if err != nil {
+		return Map{}, err
+	}
+	return Map{h}, nil
+}
+
+func (m Map) keys() []value.Value {
+	entries := m.h.entries()
+	keys := make([]value.Value, len(entries))
+	for i, e := range entries {
+		keys[i] = e.key
+	}
+	return keys
+}
+
+func (m Map) values() []value.Value {
+	entries := m.h.entries()
+	values := make([]value.Value, len(entries))
+	for i, e := range entries {
+		values[i] = e.value
+	}
+	return values
+}
+
+func eqMap(t *thread, m Map, v value.Value) (value.Value, error) {
+	m2, ok := v.(Map)
+	if !ok || m.h.len != m2.h.len {
+		return falseValue, nil
+	}
+	for _, e := range m.h.entries() {
+		v2, ok, err := m2.get(t, e.key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return falseValue, nil
+		}
+		bV, err := eq(t, e.value, v2)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := bV.(Bool)
+		if !ok {
+			return nil, fmt.Errorf("map equal: expected bool, got %s", valueString(t, bV))
+		}
+		if !b.AsBool() {
+			return falseValue, nil
+		}
+	}
+	return trueValue, nil
+}
+
+var stringEmptyMap value.Value = String("%[]")
+
+func stringerMap(t *thread, m Map) (value.Value, error) {
+	if m.h.len == 0 {
+		return stringEmptyMap, nil
+	}
+
+	var b strings.Builder
+	b.WriteString("%[")
+	for i, e := range m.h.entries() {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(valueString(t, e.key))
+		b.WriteString(" ")
+		b.WriteString(valueString(t, e.value))
+	}
+	b.WriteString("]")
+	return String(b.String()), nil
+}
+
+// matcherMap matches maps containing at least the keys of the matcher,
+// the values are matched with the matchers stored under the keys.
+func matcherMap(t *thread, matcher Map, v value.Value) (value.Value, error) {
+	candidate, ok := v.(Map)
+	if !ok {
+		return noMatch, nil
+	}
+
+	out := make([]value.Value, 0)
+	for _, e := range matcher.h.entries() {
+		c, ok, err := candidate.get(t, e.key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return noMatch, nil
+		}
+		matched, pairs, err := runMatcher(t, e.value, c)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			return noMatch, nil
+		}
+		out = append(out, pairs...)
+	}
+
+	return List{[]value.Value{trueValue, List{out}}}, nil
+}
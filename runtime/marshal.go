@@ -0,0 +1,600 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/erikfastermann/quinn/number"
+	"github.com/erikfastermann/quinn/value"
+)
+
+// Encode converts a Go value to a Quinn value.
+//
+// Bools, numbers and strings become Bool, Number and String.
+// Slices and arrays become lists, maps become maps and structs
+// become records, maps with an atom for each field. The atom is the
+// field name starting with a lower case letter or the name in the
+// quinn struct tag. Fields tagged with "-" and unexported fields are left out.
+// Funcs become blocks, which decode their arguments and encode their results.
+// Nil pointers and interfaces become unit, Values are used as is.
+func Encode(v interface{}) (value.Value, error) {
+	return encode(newThread(defaultInstance, Options{}), reflect.ValueOf(v))
+}
+
+// Decode stores the Quinn value v in the Go value ptr points to,
+// using the same rules as Encode in reverse.
+// Decoding into an empty interface stores unit as nil, numbers as
+// int if possible and float64 otherwise, strings and atoms as string,
+// lists as []interface{} and maps with string or atom keys
+// as map[string]interface{}, other values are stored as is.
+func Decode(v value.Value, ptr interface{}) error {
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("decode needs a non nil pointer")
+	}
+	t := newThread(defaultInstance, Options{})
+	t.callThread = func() *thread {
+		return newThread(defaultInstance, Options{})
+	}
+	return decode(t, v, rv.Elem())
+}
+
+func encode(t *thread, rv reflect.Value) (value.Value, error) {
+	return encodeValue(t, rv, make(map[visit]bool))
+}
+
+// visit identifies a pointer, map or slice currently being encoded.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+func encodeValue(t *thread, rv reflect.Value, seen map[visit]bool) (value.Value, error) {
+	if !rv.IsValid() {
+		return unit, nil
+	}
+	if rv.Type().Implements(typeValue) {
+		if (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) && rv.IsNil() {
+			return unit, nil
+		}
+		return rv.Interface().(value.Value), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if !rv.IsNil() {
+			v := visit{rv.Pointer(), rv.Type(), 0}
+			if rv.Kind() == reflect.Slice {
+				v.len = rv.Len()
+			}
+			if seen[v] {
+				return nil, fmt.Errorf("can't encode cyclic value of type %s", rv.Type())
+			}
+			seen[v] = true
+			defer delete(seen, v)
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if rv.IsNil() {
+			return unit, nil
+		}
+		return encodeValue(t, rv.Elem(), seen)
+	case reflect.Bool:
+		return NewBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return number.FromInt64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return number.FromUint64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return number.FromFloat64(rv.Float())
+	case reflect.String:
+		return String(rv.String()), nil
+	case reflect.Slice, reflect.Array:
+		l := make([]value.Value, rv.Len())
+		for i := range l {
+			v, err := encodeValue(t, rv.Index(i), seen)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = v
+		}
+		return List{l}, nil
+	case reflect.Map:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return lessKey(keys[i], keys[j])
+		})
+		var m Map
+		for _, k := range keys {
+			kV, err := encodeValue(t, k, seen)
+			if err != nil {
+				return nil, err
+			}
+			v, err := encodeValue(t, rv.MapIndex(k), seen)
+			if err != nil {
+				return nil, err
+			}
+			if m, err = m.insert(t, kV, v); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case reflect.Struct:
+		var m Map
+		for i := 0; i < rv.NumField(); i++ {
+			name, ok := fieldName(rv.Type().Field(i))
+			if !ok {
+				continue
+			}
+			v, err := encodeValue(t, rv.Field(i), seen)
+			if err != nil {
+				return nil, err
+			}
+			if m, err = m.insert(t, Atom(name), v); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case reflect.Func:
+		if rv.IsNil() {
+			return unit, nil
+		}
+		return newGoFuncBlock(rv)
+	default:
+		return nil, fmt.Errorf("can't encode values of type %s", rv.Type())
+	}
+}
+
+// lessKey orders map keys by kind, then numbers and strings by value.
+func lessKey(x, y reflect.Value) bool {
+	for x.Kind() == reflect.Interface && !x.IsNil() {
+		x = x.Elem()
+	}
+	for y.Kind() == reflect.Interface && !y.IsNil() {
+		y = y.Elem()
+	}
+	if x.Kind() != y.Kind() {
+		return x.Kind() < y.Kind()
+	}
+	switch x.Kind() {
+	case reflect.Bool:
+		return !x.Bool() && y.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return x.Int() < y.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return x.Uint() < y.Uint()
+	case reflect.Float32, reflect.Float64:
+		return x.Float() < y.Float()
+	case reflect.String:
+		return x.String() < y.String()
+	default:
+		return fmt.Sprint(x) < fmt.Sprint(y)
+	}
+}
+
+func fieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+	name := f.Tag.Get("quinn")
+	if i := strings.IndexByte(name, ','); i >= 0 {
+		name = name[:i]
+	}
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		r, size := utf8.DecodeRuneInString(f.Name)
+		return string(unicode.ToLower(r)) + f.Name[size:], true
+	default:
+		return name, true
+	}
+}
+
+func decode(t *thread, v value.Value, rv reflect.Value) error {
+	if v == nil {
+		v = unit
+	}
+	typ := rv.Type()
+	if reflect.TypeOf(v).AssignableTo(typ) && (typ.Kind() != reflect.Interface || typ.NumMethod() > 0) {
+		rv.Set(reflect.ValueOf(v))
+		return nil
+	}
+	mismatch := func() error {
+		return fmt.Errorf("can't decode %s into %s", valueString(t, v), typ)
+	}
+
+	switch typ.Kind() {
+	case reflect.Interface:
+		if typ.NumMethod() > 0 {
+			return mismatch()
+		}
+		natural, err := decodeNatural(t, v)
+		if err != nil {
+			return err
+		}
+		if natural == nil {
+			rv.Set(reflect.Zero(typ))
+		} else {
+			rv.Set(reflect.ValueOf(natural))
+		}
+	case reflect.Ptr:
+		if _, ok := v.(Unit); ok {
+			rv.Set(reflect.Zero(typ))
+			return nil
+		}
+		p := reflect.New(typ.Elem())
+		if err := decode(t, v, p.Elem()); err != nil {
+			return err
+		}
+		rv.Set(p)
+	case reflect.Bool:
+		b, ok := v.(Bool)
+		if !ok {
+			return mismatch()
+		}
+		rv.SetBool(b.AsBool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := v.(number.Number)
+		if !ok {
+			return mismatch()
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return err
+		}
+		if rv.OverflowInt(i) {
+			return fmt.Errorf("%s overflows %s", n, typ)
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := v.(number.Number)
+		if !ok {
+			return mismatch()
+		}
+		u, err := n.Uint64()
+		if err != nil {
+			return err
+		}
+		if rv.OverflowUint(u) {
+			return fmt.Errorf("%s overflows %s", n, typ)
+		}
+		rv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		n, ok := v.(number.Number)
+		if !ok {
+			return mismatch()
+		}
+		rv.SetFloat(n.Float64())
+	case reflect.String:
+		switch v := v.(type) {
+		case String:
+			rv.SetString(string(v))
+		case Atom:
+			rv.SetString(string(v))
+		default:
+			return mismatch()
+		}
+	case reflect.Slice:
+		l, ok := v.(List)
+		if !ok {
+			return mismatch()
+		}
+		s := reflect.MakeSlice(typ, len(l.data), len(l.data))
+		for i, elem := range l.data {
+			if err := decode(t, elem, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(s)
+	case reflect.Array:
+		l, ok := v.(List)
+		if !ok || len(l.data) != typ.Len() {
+			return mismatch()
+		}
+		for i, elem := range l.data {
+			if err := decode(t, elem, rv.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := v.(Map)
+		if !ok {
+			return mismatch()
+		}
+		out := reflect.MakeMapWithSize(typ, m.h.len)
+		for _, e := range m.h.entries() {
+			k := reflect.New(typ.Key()).Elem()
+			if err := decode(t, e.key, k); err != nil {
+				return err
+			}
+			elem := reflect.New(typ.Elem()).Elem()
+			if err := decode(t, e.value, elem); err != nil {
+				return err
+			}
+			out.SetMapIndex(k, elem)
+		}
+		rv.Set(out)
+	case reflect.Struct:
+		m, ok := v.(Map)
+		if !ok {
+			return mismatch()
+		}
+		for i := 0; i < typ.NumField(); i++ {
+			name, ok := fieldName(typ.Field(i))
+			if !ok {
+				continue
+			}
+			fieldV, ok, err := m.get(t, Atom(name))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if err := decode(t, fieldV, rv.Field(i)); err != nil {
+				return fmt.Errorf("field %s: %w", name, err)
+			}
+		}
+	case reflect.Func:
+		b, ok := v.(Block)
+		if !ok {
+			return mismatch()
+		}
+		rv.Set(blockFunc(t, b, typ))
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+func decodeNatural(t *thread, v value.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case Unit:
+		return nil, nil
+	case Bool:
+		return v.AsBool(), nil
+	case number.Number:
+		if i, err := v.Signed(); err == nil {
+			return i, nil
+		}
+		return v.Float64(), nil
+	case String:
+		return string(v), nil
+	case Atom:
+		return string(v), nil
+	case List:
+		l := make([]interface{}, len(v.data))
+		for i, elem := range v.data {
+			var err error
+			if l[i], err = decodeNatural(t, elem); err != nil {
+				return nil, err
+			}
+		}
+		return l, nil
+	case Map:
+		m := make(map[string]interface{}, v.h.len)
+		for _, e := range v.h.entries() {
+			var k string
+			switch key := e.key.(type) {
+			case String:
+				k = string(key)
+			case Atom:
+				k = string(key)
+			default:
+				return nil, fmt.Errorf("can't decode map with key %s", valueString(t, e.key))
+			}
+			elem, err := decodeNatural(t, e.value)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = elem
+		}
+		return m, nil
+	default:
+		return v, nil
+	}
+}
+
+// blockFuncPanic is the panic value of a func returned by blockFunc
+// without an error result. A goFuncBlock calling the func recovers it.
+type blockFuncPanic struct {
+	err error
+}
+
+func (p blockFuncPanic) Error() string {
+	return p.err.Error()
+}
+
+func (p blockFuncPanic) Unwrap() error {
+	return p.err
+}
+
+// blockFunc returns a Go func of type typ calling b. If the last result
+// of typ is an error, errors are returned there, otherwise the func panics
+// with a blockFuncPanic.
+// With more than one other result, b has to return a list of the results.
+// Called while the decoding thread runs code, b runs as part of that run,
+// sharing its limits, context and streams. Otherwise each call is a new run.
+func blockFunc(decoder *thread, b Block, typ reflect.Type) reflect.Value {
+	return reflect.MakeFunc(typ, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, typ.NumOut())
+		for i := range out {
+			out[i] = reflect.New(typ.Out(i)).Elem()
+		}
+		results := out
+		hasErr := typ.NumOut() > 0 && typ.Out(typ.NumOut()-1) == typeError
+		if hasErr {
+			results = out[:len(out)-1]
+		}
+		fail := func(err error) []reflect.Value {
+			if !hasErr {
+				panic(blockFuncPanic{err})
+			}
+			out[len(out)-1] = reflect.ValueOf(&err).Elem()
+			return out
+		}
+
+		var t *thread
+		if decoder.callThread != nil {
+			t = decoder.callThread()
+			defer t.finish()
+		} else {
+			t = decoder.spawn()
+			t.depth = decoder.depth
+		}
+		if typ.IsVariadic() {
+			last := in[len(in)-1]
+			in = in[:len(in)-1]
+			for i := 0; i < last.Len(); i++ {
+				in = append(in, last.Index(i))
+			}
+		}
+		args := make([]value.Value, len(in))
+		for i, arg := range in {
+			v, err := encode(t, arg)
+			if err != nil {
+				return fail(err)
+			}
+			args[i] = v
+		}
+		v, err := b.runWithoutEnv(t, args...)
+		if flushErr := t.streams.flush(); err == nil {
+			err = flushErr
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		switch len(results) {
+		case 0:
+		case 1:
+			if err := decode(t, v, results[0]); err != nil {
+				return fail(err)
+			}
+		default:
+			l, ok := v.(List)
+			if !ok || len(l.data) != len(results) {
+				return fail(fmt.Errorf("expected a list of %d results, got %s", len(results), valueString(t, v)))
+			}
+			for i := range results {
+				if err := decode(t, l.data[i], results[i]); err != nil {
+					return fail(err)
+				}
+			}
+		}
+		return out
+	})
+}
+
+// goFuncBlock calls a Go func, decoding the arguments and encoding the results.
+// A last error result is returned as the error of the call,
+// multiple other results are returned as a list.
+type goFuncBlock struct {
+	fn reflect.Value
+}
+
+// NewFuncBlock converts any Go func to a block, which decodes
+// its arguments and encodes its results like Encode does.
+// Use NewBlock for funcs working on Quinn values directly.
+func NewFuncBlock(fn interface{}) (Block, error) {
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		return nil, fmt.Errorf("expected func, got %T", fn)
+	}
+	return newGoFuncBlock(rv)
+}
+
+func newGoFuncBlock(fn reflect.Value) (Block, error) {
+	typ := fn.Type()
+	for i := 0; i < typ.NumIn(); i++ {
+		in := typ.In(i)
+		if in == typePtrThread || in == typePtrEnvironment {
+			return nil, fmt.Errorf("func %s can't take a %s", typ, in)
+		}
+	}
+	return goFuncBlock{fn}, nil
+}
+
+func (goFuncBlock) Tag() value.Tag {
+	return tagBlock
+}
+
+func (b goFuncBlock) runWithoutEnv(t *thread, args ...value.Value) (_ value.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p, ok := r.(blockFuncPanic)
+			if !ok {
+				panic(r)
+			}
+			err = p.err
+		}
+	}()
+
+	typ := b.fn.Type()
+	min := typ.NumIn()
+	if min == 0 && len(args) == 1 {
+		if _, ok := args[0].(Unit); ok {
+			args = nil
+		}
+	}
+	if typ.IsVariadic() {
+		min--
+		if len(args) < min {
+			return nil, fmt.Errorf("expected at least %d arguments, got %d", min, len(args))
+		}
+	} else if len(args) != min {
+		return nil, fmt.Errorf("expected %d arguments, got %d", min, len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var inType reflect.Type
+		if typ.IsVariadic() && i >= min {
+			inType = typ.In(min).Elem()
+		} else {
+			inType = typ.In(i)
+		}
+		in[i] = reflect.New(inType).Elem()
+		if err := decode(t, arg, in[i]); err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+	}
+
+	out := b.fn.Call(in)
+	if len(out) > 0 && typ.Out(len(out)-1) == typeError {
+		if err := out[len(out)-1].Interface(); err != nil {
+			return nil, err.(error)
+		}
+		out = out[:len(out)-1]
+	}
+	switch len(out) {
+	case 0:
+		return unit, nil
+	case 1:
+		return encode(t, out[0])
+	default:
+		l := make([]value.Value, len(out))
+		for i, o := range out {
+			v, err := encode(t, o)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = v
+		}
+		return List{l}, nil
+	}
+}
+
+func (b goFuncBlock) runWithEnv(t *thread, env *Environment, args ...value.Value) (*Environment, value.Value, error) {
+	v, err := b.runWithoutEnv(t, args...)
+	return env, v, err
+}
@@ -0,0 +1,174 @@
+package runtime
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/erikfastermann/quinn/value"
+)
+
+type testRecord struct {
+	Name    string
+	Age     int `quinn:"years"`
+	Tags    []string
+	Scores  map[string]float64
+	Next    *testRecord
+	private int
+	Skipped bool `quinn:"-"`
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	values := []interface{}{
+		true,
+		int64(-42),
+		uint8(200),
+		1.5,
+		"hello",
+		[]int{1, 2, 3},
+		[2]string{"a", "b"},
+		map[string]int{"x": 1, "y": 2},
+		testRecord{
+			Name:   "quinn",
+			Age:    3,
+			Tags:   []string{"lang"},
+			Scores: map[string]float64{"speed": 0.5},
+			Next:   &testRecord{Name: "next", Tags: []string{}, Scores: map[string]float64{}},
+		},
+	}
+	for _, want := range values {
+		v, err := Encode(want)
+		if err != nil {
+			t.Fatalf("encode %#v: %v", want, err)
+		}
+		got := reflect.New(reflect.TypeOf(want))
+		if err := Decode(v, got.Interface()); err != nil {
+			t.Fatalf("decode %#v: %v", want, err)
+		}
+		if !reflect.DeepEqual(got.Elem().Interface(), want) {
+			t.Fatalf("round trip of %#v gave %#v", want, got.Elem().Interface())
+		}
+	}
+}
+
+func TestEncodeRecordFields(t *testing.T) {
+	v, err := Encode(testRecord{Age: 3, private: 1, Skipped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := v.(Map)
+	th := testThread()
+	for _, name := range []Atom{"name", "years", "tags", "scores", "next"} {
+		if _, ok, _ := m.get(th, name); !ok {
+			t.Errorf("missing field %s", name)
+		}
+	}
+	for _, name := range []Atom{"age", "private", "skipped"} {
+		if _, ok, _ := m.get(th, name); ok {
+			t.Errorf("unexpected field %s", name)
+		}
+	}
+}
+
+func TestEncodeCycle(t *testing.T) {
+	r := &testRecord{Name: "loop"}
+	r.Next = r
+	if _, err := Encode(r); err == nil || !strings.Contains(err.Error(), "cyclic") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+
+	l := []interface{}{nil}
+	l[0] = l
+	if _, err := Encode(l); err == nil {
+		t.Fatal("expected cycle error for slice")
+	}
+
+	shared := &testRecord{Name: "shared"}
+	if _, err := Encode([]*testRecord{shared, shared}); err != nil {
+		t.Fatalf("shared pointer is not a cycle: %v", err)
+	}
+}
+
+func TestDecodedFuncErrors(t *testing.T) {
+	in := NewInterpreter(Options{})
+	apply, err := NewFuncBlock(func(f func(int) int) int { return f(1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Define("apply", apply); err != nil {
+		t.Fatal(err)
+	}
+	_, err = in.EvalString("apply (['x] -> { x / 0 })")
+	if err == nil || !strings.Contains(err.Error(), "denominator is zero") {
+		t.Fatalf("expected division error, got %v", err)
+	}
+
+	v, err := in.EvalString("apply (['x] -> { x + 1 })")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := Decode(v, &n); err != nil || n != 2 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+
+	var f func(int) (int, error)
+	if err := Decode(mustEval(t, in, "['x] -> { x / 0 }"), &f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f(1); err == nil {
+		t.Fatal("expected error result")
+	}
+}
+
+func mustEval(t *testing.T, in *Interpreter, src string) value.Value {
+	t.Helper()
+	v, err := in.EvalString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+type testHandle struct{}
+
+var tagTestHandle = value.NewTag()
+
+func (testHandle) Tag() value.Tag {
+	return tagTestHandle
+}
+
+func TestInterpreterDecodeUsesInstance(t *testing.T) {
+	var out strings.Builder
+	in := NewInterpreter(Options{Stdout: &out})
+	err := in.RegisterType(tagTestHandle, map[value.Tag]interface{}{
+		StringerTag(): func(testHandle) (value.Value, error) { return NewString("handle"), nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var show func(interface{})
+	if err := in.Decode(mustEval(t, in, "['v] -> { println v }"), &show); err != nil {
+		t.Fatal(err)
+	}
+	show(testHandle{})
+	if got := out.String(); got != "handle\n" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestEncodeMapKeyOrder(t *testing.T) {
+	v, err := Encode(map[interface{}]int{10: 0, 9: 0, -1: 0, "b": 0, "a": 0, 2.5: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := v.(Map).h.entries()
+	got := make([]string, len(keys))
+	for i, e := range keys {
+		got[i] = valueString(testThread(), e.key)
+	}
+	if s := strings.Join(got, " "); s != `-1 9 10 5/2 "a" "b"` {
+		t.Errorf("got keys %s", s)
+	}
+}
@@ -0,0 +1,171 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/erikfastermann/quinn/value"
+)
+
+var errNoMatch = errors.New("no pattern matched")
+
+var (
+	tagOrPattern    = value.NewTag()
+	tagGuardPattern = value.NewTag()
+	tagPinPattern   = value.NewTag()
+)
+
+// orPattern matches if any of its alternatives matches,
+// the bindings of the first matching alternative are used.
+type orPattern struct {
+	alts []value.Value
+}
+
+func (orPattern) Tag() value.Tag {
+	return tagOrPattern
+}
+
+func newOrPattern(x, y value.Value) orPattern {
+	var alts []value.Value
+	for _, v := range []value.Value{x, y} {
+		if p, ok := v.(orPattern); ok {
+			alts = append(alts, p.alts...)
+		} else {
+			alts = append(alts, v)
+		}
+	}
+	return orPattern{alts}
+}
+
+func matcherOrPattern(t *thread, p orPattern, v value.Value) (value.Value, error) {
+	for _, alt := range p.alts {
+		matched, pairs, err := runMatcher(t, alt, v)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			return List{[]value.Value{trueValue, List{pairs}}}, nil
+		}
+	}
+	return noMatch, nil
+}
+
+func stringerOrPattern(t *thread, p orPattern) (value.Value, error) {
+	s := valueString(t, p.alts[0])
+	for _, alt := range p.alts[1:] {
+		s += " | " + valueString(t, alt)
+	}
+	return String("(" + s + ")"), nil
+}
+
+// guardPattern only matches if the condition is true,
+// the condition is run with the bindings of the pattern.
+type guardPattern struct {
+	pattern value.Value
+	cond    basicBlock
+}
+
+func (guardPattern) Tag() value.Tag {
+	return tagGuardPattern
+}
+
+func matcherGuardPattern(t *thread, p guardPattern, v value.Value) (value.Value, error) {
+	matched, pairs, err := runMatcher(t, p.pattern, v)
+	if err != nil || !matched {
+		return noMatch, err
+	}
+	env, err := bindPairs(t, p.cond.env.newScope(), pairs)
+	if err != nil {
+		return nil, err
+	}
+	cond := p.cond
+	cond.env = env
+	okV, err := cond.runWithoutEnv(t)
+	if err != nil {
+		return nil, err
+	}
+	ok, isBool := okV.(Bool)
+	if !isBool {
+		return nil, fmt.Errorf("guard must return a bool, got %s", valueString(t, okV))
+	}
+	if !ok.AsBool() {
+		return noMatch, nil
+	}
+	return List{[]value.Value{trueValue, List{pairs}}}, nil
+}
+
+func stringerGuardPattern(t *thread, p guardPattern) (value.Value, error) {
+	return String(fmt.Sprintf("(guard %s <block>)", valueString(t, p.pattern))), nil
+}
+
+// pinPattern matches values equal to v, even if v is an atom.
+type pinPattern struct {
+	v value.Value
+}
+
+func (pinPattern) Tag() value.Tag {
+	return tagPinPattern
+}
+
+func matcherPinPattern(t *thread, p pinPattern, v value.Value) (value.Value, error) {
+	return matcherEq(t, p.v, v)
+}
+
+func stringerPinPattern(t *thread, p pinPattern) (value.Value, error) {
+	return String(fmt.Sprintf("(pin %s)", valueString(t, p.v))), nil
+}
+
+// bindPairs inserts the atom and value pairs returned by a matcher into env.
+func bindPairs(t *thread, env *Environment, pairs []value.Value) (*Environment, error) {
+	const errMsg = "expected a list of unique atom and value pairs" +
+		", got %s instead"
+	for _, pairV := range pairs {
+		pair, ok := pairV.(List)
+		if !ok || len(pair.data) != 2 {
+			return nil, fmt.Errorf(errMsg, valueString(t, List{pairs}))
+		}
+		atom, ok := pair.data[0].(Atom)
+		if !ok {
+			return nil, fmt.Errorf(errMsg, valueString(t, List{pairs}))
+		}
+		env, ok = env.insert(atom, pair.data[1])
+		if !ok {
+			return nil, fmt.Errorf(
+				"can't bind %s, already exists in this scope",
+				valueString(t, atom),
+			)
+		}
+	}
+	return env, nil
+}
+
+// match returns a tail call to the block of the first arm matching v.
+func match(t *thread, v value.Value, arms List) (value.Value, error) {
+	if len(arms.data)%2 != 0 {
+		return nil, fmt.Errorf(
+			"match expects pattern and block pairs, got %d elements",
+			len(arms.data),
+		)
+	}
+	for i := 0; i < len(arms.data); i += 2 {
+		pattern, body := arms.data[i], arms.data[i+1]
+		bb, ok := body.(basicBlock)
+		if !ok {
+			return nil, errNonBasicBlock
+		}
+		matched, pairs, err := runMatcher(t, pattern, v)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+		env, err := bindPairs(t, bb.env.newScope(), pairs)
+		if err != nil {
+			return nil, err
+		}
+		bb.env = env
+		return tailCall{bb, nil}, nil
+	}
+	return nil, fmt.Errorf("%w %s", errNoMatch, valueString(t, v))
+}
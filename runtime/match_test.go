@@ -0,0 +1,47 @@
+package runtime
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	const describe = `'describe = def ['v] {
+	match v [
+		0 { "zero" }
+		(1 | (2 | 3)) { "small" }
+		['first '_] { first }
+		%['name 'who] { who }
+		(guard 'n { n < 0 }) { "negative" }
+		'_ { "other" }
+	]
+}
+`
+	runCases(t, []evalCase{
+		{name: "literal", src: describe + "'got = (describe 0)", want: `"zero"`},
+		{name: "or", src: describe + "'got = [(describe 1) (describe 3)]", want: `["small" "small"]`},
+		{name: "list", src: describe + "'got = (describe [7 8])", want: "7"},
+		{name: "list length", src: "'got = (match [7 8 9] [['a 'b] { 'two } '_ { 'other }])", want: "'other"},
+		{name: "map", src: describe + "'got = (describe %['name \"ada\" 'age 36])", want: `"ada"`},
+		{name: "guard", src: describe + "'got = (describe (neg 5))", want: `"negative"`},
+		{name: "wildcard", src: describe + "'got = (describe 100)", want: `"other"`},
+		{name: "first arm wins", src: "'got = (match 1 ['x { 'first } 1 { 'second }])", want: "'first"},
+		{name: "nested", src: "'got = (match [1 [2 3]] [['a ['b 'c]] { [c b a] }])", want: "[3 2 1]"},
+		{name: "pin", src: "'x = 2\n'got = (match 2 [(pin x) { 'pinned } '_ { 'other }])", want: "'pinned"},
+		{name: "pin mismatch", src: "'x = 2\n'got = (match 3 [(pin x) { 'pinned } '_ { 'other }])", want: "'other"},
+		{name: "no match", src: "match 5 [4 { () }]", err: "no pattern matched 5"},
+		{name: "guard result", src: "match 5 [(guard 'n { 1 }) { () }]", err: "guard must return a bool"},
+		{name: "odd arms", src: "match 5 [4]", err: "pattern and block pairs"},
+	})
+}
+
+func TestDestructuring(t *testing.T) {
+	runCases(t, []evalCase{
+		{name: "list", src: "['a 'b] = [1 2]\n'got = [b a]", want: "[2 1]"},
+		{name: "map", src: "%['x 'px] = %['x 1 'y 2]\n'got = px", want: "1"},
+		{name: "nested", src: "['a ['b 'c]] = [1 [2 3]]\n'got = [a b c]", want: "[1 2 3]"},
+		{name: "wildcard", src: "['_ 'b] = [1 2]\n'got = b", want: "2"},
+		{name: "wildcard atom", src: "'_ = 5\n'got = 1", want: "1"},
+		{name: "guard", src: "(guard 'n { n > 0 }) = 5\n'got = n", want: "5"},
+		{name: "mismatch", src: "['a 'b] = [1]", err: "no pattern matched [1], expected [a b]"},
+		{name: "guard mismatch", src: "(guard 'n { n > 0 }) = 0", err: "no pattern matched 0"},
+		{name: "existing name", src: "'a = 1\n['a 'b] = [1 2]", err: "already exists"},
+	})
+}
@@ -2,14 +2,109 @@ package runtime
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/erikfastermann/quinn/value"
 )
 
 var tagMut = value.NewTag()
 
+var mutIDs uint64
+
 type Mut struct {
-	v value.Value
+	// id orders the locking of several Muts
+	id uint64
+
+	mu sync.Mutex
+	// version is incremented on every store
+	version uint64
+	v       value.Value
+}
+
+func newMut(v value.Value) *Mut {
+	return &Mut{id: atomic.AddUint64(&mutIDs, 1), v: v}
+}
+
+func (m *Mut) load() value.Value {
+	v, _ := m.snapshot()
+	return v
+}
+
+func (m *Mut) snapshot() (value.Value, uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.v, m.version
+}
+
+func (m *Mut) store(v value.Value) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.v = v
+	m.version++
+}
+
+// storeIf stores v if m wasn't changed since version.
+func (m *Mut) storeIf(version uint64, v value.Value) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.version != version {
+		return false
+	}
+	m.v = v
+	m.version++
+	return true
+}
+
+// update sets m to the result of b called with the current value.
+// If m changes while b runs, b is called again with the new value.
+func update(t *thread, m *Mut, b Block) (value.Value, error) {
+	if t.tx != nil {
+		v, err := t.tx.load(m)
+		if err != nil {
+			return nil, err
+		}
+		next, err := b.runWithoutEnv(t, v)
+		if err != nil {
+			return nil, err
+		}
+		t.tx.store(m, next)
+		return next, nil
+	}
+	for {
+		v, version := m.snapshot()
+		next, err := b.runWithoutEnv(t, v)
+		if err != nil {
+			return nil, err
+		}
+		if m.storeIf(version, next) {
+			return next, nil
+		}
+	}
+}
+
+// cas sets m to next if its current value is equal to old.
+func cas(t *thread, m *Mut, old, next value.Value) (value.Value, error) {
+	if t.tx != nil {
+		v, err := t.tx.load(m)
+		if err != nil {
+			return nil, err
+		}
+		if ok, err := isEqual(t, v, old); err != nil || !ok {
+			return falseValue, err
+		}
+		t.tx.store(m, next)
+		return trueValue, nil
+	}
+	for {
+		v, version := m.snapshot()
+		if ok, err := isEqual(t, v, old); err != nil || !ok {
+			return falseValue, err
+		}
+		if m.storeIf(version, next) {
+			return trueValue, nil
+		}
+	}
 }
 
 func (*Mut) Tag() value.Tag {
@@ -17,15 +112,15 @@ func (*Mut) Tag() value.Tag {
 }
 
 // TODO: should Mut implement eq?
-func eqMut(m *Mut, v value.Value) (value.Value, error) {
+func eqMut(t *thread, m *Mut, v value.Value) (value.Value, error) {
 	m2, ok := v.(*Mut)
 	if !ok {
 		return falseValue, nil
 	}
 	// TODO: check cycle?
-	return eq(m.v, m2.v)
+	return eq(t, m.load(), m2.load())
 }
 
-func stringerMut(m *Mut) (value.Value, error) {
-	return String(fmt.Sprintf("(mut %s)", valueString(m.v))), nil
+func stringerMut(t *thread, m *Mut) (value.Value, error) {
+	return String(fmt.Sprintf("(mut %s)", valueString(t, m.load()))), nil
 }
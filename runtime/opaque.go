@@ -19,6 +19,10 @@ func opaqueMatcher(v value.Value, tag value.Tag) (value.Value, bool) {
 	if !ok {
 		return nil, false
 	}
+	return o.attr(tag)
+}
+
+func (o Opaque) attr(tag value.Tag) (value.Value, bool) {
 	attr, ok := o.attrs[tag]
 	return attr, ok
 }
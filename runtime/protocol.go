@@ -0,0 +1,172 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/erikfastermann/quinn/number"
+	"github.com/erikfastermann/quinn/value"
+)
+
+var (
+	tagProtocol = value.NewTag()
+	// tagProtocols is the attribute listing the protocols a value implements,
+	// opaque checks them when the value is created.
+	tagProtocols = value.NewTag()
+)
+
+var errInvalidProtocol = errors.New("protocol requirements must be a map of names to tag and arity pairs")
+
+// Protocol is a named set of attributes a value has to provide.
+type Protocol struct {
+	name Atom
+	reqs []protocolReq
+}
+
+type protocolReq struct {
+	name  Atom
+	tag   value.Tag
+	arity int
+}
+
+func (*Protocol) Tag() value.Tag {
+	return tagProtocol
+}
+
+var (
+	protocolEq       = &Protocol{"eq", []protocolReq{{"eq", tagEq, 2}}}
+	protocolStringer = &Protocol{"stringer", []protocolReq{{"stringer", tagStringer, 1}}}
+	protocolMatcher  = &Protocol{"matcher", []protocolReq{{"matcher", tagMatcher, 2}}}
+)
+
+func newProtocol(name Atom, reqs Map) (*Protocol, error) {
+	p := &Protocol{name: name}
+	for _, e := range reqs.h.entries() {
+		reqName, ok := e.key.(Atom)
+		if !ok {
+			return nil, errInvalidProtocol
+		}
+		pair, ok := e.value.(List)
+		if !ok || len(pair.data) != 2 {
+			return nil, errInvalidProtocol
+		}
+		tag, ok := pair.data[0].(value.Tag)
+		if !ok {
+			return nil, errInvalidProtocol
+		}
+		n, ok := pair.data[1].(number.Number)
+		if !ok {
+			return nil, errInvalidProtocol
+		}
+		arity, err := n.Unsigned()
+		if err != nil {
+			return nil, err
+		}
+		p.reqs = append(p.reqs, protocolReq{reqName, tag, arity})
+	}
+	return p, nil
+}
+
+// check returns an error describing the first requirement
+// the attributes don't meet, the message is meant to be
+// prefixed with a description of the value.
+func (p *Protocol) check(attrs func(value.Tag) (value.Value, bool)) error {
+	for _, req := range p.reqs {
+		attr, ok := attrs(req.tag)
+		if !ok {
+			return fmt.Errorf("doesn't implement %s: missing %s", p.name, req.name)
+		}
+		b, ok := attr.(Block)
+		if !ok {
+			return fmt.Errorf("doesn't implement %s: %s is not a block", p.name, req.name)
+		}
+		if !acceptsArity(b, req.arity) {
+			return fmt.Errorf(
+				"doesn't implement %s: %s doesn't take %d argument(s)",
+				p.name,
+				req.name,
+				req.arity,
+			)
+		}
+	}
+	return nil
+}
+
+// valueAttrs returns the attributes of v for check.
+func valueAttrs(t *thread, v value.Value) func(value.Tag) (value.Value, bool) {
+	return func(tag value.Tag) (value.Value, bool) {
+		attr, err := getAttribute(t, v, tag)
+		return attr, err == nil
+	}
+}
+
+// checkProtocols checks the protocols listed in a tagProtocols attribute.
+func checkProtocols(t *thread, attrs func(value.Tag) (value.Value, bool), protocols value.Value) error {
+	list, ok := protocols.(List)
+	if !ok {
+		list = List{[]value.Value{protocols}}
+	}
+	for _, pV := range list.data {
+		p, ok := pV.(*Protocol)
+		if !ok {
+			return fmt.Errorf("claims %s, which is not a protocol", valueString(t, pV))
+		}
+		if err := p.check(attrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func eqProtocol(p *Protocol, v value.Value) (value.Value, error) {
+	p2, ok := v.(*Protocol)
+	return NewBool(ok && p == p2), nil
+}
+
+func stringerProtocol(p *Protocol) (value.Value, error) {
+	return String(fmt.Sprintf("(protocol %s)", p.name)), nil
+}
+
+// blockArity returns the minimum and maximum number of arguments b takes,
+// max is negative if there is no upper bound.
+func blockArity(b Block) (min, max int) {
+	switch b := b.(type) {
+	case *funcBlock:
+		if b.rest != "" {
+			return b.required, -1
+		}
+		return b.required, len(b.params)
+	case basicBlock:
+		return 0, 1
+	case tailBlock:
+		return blockArity(b.b)
+	case fnBlockWithoutEnv:
+		if b.slice != nil {
+			return len(b.in), -1
+		}
+		return len(b.in), len(b.in)
+	case fnBlockWithEnv:
+		if b.slice != nil {
+			return len(b.in), -1
+		}
+		return len(b.in), len(b.in)
+	case *generator, *continuation:
+		return 0, 1
+	case goFuncBlock:
+		n := b.fn.Type().NumIn()
+		if b.fn.Type().IsVariadic() {
+			return n - 1, -1
+		}
+		if n == 0 {
+			return 0, 1
+		}
+		return n, n
+	default:
+		return 0, -1
+	}
+}
+
+func acceptsArity(b Block, n int) bool {
+	min, max := blockArity(b)
+	return n >= min && (max < 0 || n <= max)
+}
@@ -0,0 +1,43 @@
+package runtime
+
+import "testing"
+
+func TestProtocols(t *testing.T) {
+	const shape = `'tagArea = newTag ()
+'shape = protocol 'shape %['area [tagArea 1]]
+'tagSquare = newTag ()
+'square = def ['n] {
+	opaque n tagSquare %[
+		tagArea (['s] -> { n * n })
+		tagProtocols shape
+	]
+}
+`
+	runCases(t, []evalCase{
+		{name: "conforming", src: shape + "'got = (implements (square 3) shape)", want: "true"},
+		{name: "attribute", src: shape + "'sq = square 3\n'got = ((attr sq tagArea) sq)", want: "9"},
+		{name: "missing attribute", src: shape + "'got = (implements 3 shape)", want: "false"},
+		{name: "builtin stringer", src: "'got = [(implements 3 protocolStringer) (implements 3 protocolEq)]", want: "[true true]"},
+		{name: "protocol equality", src: shape + "'got = [(shape == shape) (shape == protocolEq)]", want: "[true false]"},
+		{name: "arity of function", src: "'got = (arity (['a ['b 1]] -> { a }))", want: "[1 2]"},
+		{name: "arity of rest", src: "'got = (arity (['a (rest 'xs)] -> { a }))", want: "[1 ()]"},
+		{
+			name: "wrong arity",
+			src:  shape + "'got = (implements (opaque 1 tagSquare %[tagArea (['a 'b] -> { a })]) shape)",
+			want: "false",
+		},
+		{name: "checked by opaque", src: shape + "opaque 3 tagSquare %[tagProtocols shape]", err: "opaque value doesn't implement shape: missing area"},
+		{
+			name: "attribute not a block",
+			src:  shape + "opaque 3 tagSquare %[tagArea 1 tagProtocols shape]",
+			err:  "doesn't implement shape: area is not a block",
+		},
+		{
+			name: "arity checked by opaque",
+			src:  shape + "opaque 3 tagSquare %[tagArea (['a 'b] -> { a }) tagProtocols shape]",
+			err:  "area doesn't take 1 argument(s)",
+		},
+		{name: "not a protocol", src: "opaque 3 (newTag ()) %[tagProtocols 1]", err: "claims 1, which is not a protocol"},
+		{name: "invalid requirements", src: "protocol 'p %['x 1]", err: "protocol requirements must be a map"},
+	})
+}
@@ -0,0 +1,67 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/erikfastermann/quinn/value"
+)
+
+// EqTag is the tag of the attribute used by ==.
+// It is called with the value and the value it is compared to
+// and returns a Bool.
+func EqTag() value.Tag {
+	return tagEq
+}
+
+// StringerTag is the tag of the attribute used by println.
+// It is called with the value and returns a String.
+func StringerTag() value.Tag {
+	return tagStringer
+}
+
+// MatcherTag is the tag of the attribute used by match.
+// It is called with the pattern and the value to match
+// and returns a list of a Bool and the atom and value pairs to bind.
+func MatcherTag() value.Tag {
+	return tagMatcher
+}
+
+// ProtocolsTag is the tag of the attribute listing the protocols
+// a type implements.
+func ProtocolsTag() value.Tag {
+	return tagProtocols
+}
+
+// RegisterType makes values with the given tag usable in Quinn code run with Run.
+// Each attribute is either a Value or a Go func, which is converted
+// with NewBlock. Types can't be registered twice.
+func RegisterType(tag value.Tag, attrs map[value.Tag]interface{}) error {
+	return defaultInstance.registerType(tag, attrs)
+}
+
+func (inst *instance) registerType(tag value.Tag, attrs map[value.Tag]interface{}) error {
+	m := make(map[value.Tag]value.Value, len(attrs))
+	for attrTag, attr := range attrs {
+		v, ok := attr.(value.Value)
+		if !ok {
+			b, err := NewBlock(attr)
+			if err != nil {
+				return fmt.Errorf("invalid attribute: %w", err)
+			}
+			v = b
+		}
+		m[attrTag] = v
+	}
+	lookup := func(attrTag value.Tag) (value.Value, bool) {
+		v, ok := m[attrTag]
+		return v, ok
+	}
+	if protocols, ok := m[tagProtocols]; ok {
+		if err := checkProtocols(newThread(inst, Options{}), lookup, protocols); err != nil {
+			return fmt.Errorf("type %w", err)
+		}
+	}
+	return inst.types.register(tag, func(_ value.Value, attrTag value.Tag) (value.Value, bool) {
+		return lookup(attrTag)
+	})
+}
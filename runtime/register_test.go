@@ -0,0 +1,97 @@
+package runtime
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/erikfastermann/quinn/parser"
+	"github.com/erikfastermann/quinn/value"
+)
+
+var tagTestPoint = value.NewTag()
+
+type testPoint struct{ x, y int }
+
+func (testPoint) Tag() value.Tag {
+	return tagTestPoint
+}
+
+func init() {
+	err := RegisterType(tagTestPoint, map[value.Tag]interface{}{
+		StringerTag(): func(p testPoint) (value.Value, error) {
+			return String("point"), nil
+		},
+		EqTag(): func(p testPoint, v value.Value) (value.Value, error) {
+			p2, ok := v.(testPoint)
+			return NewBool(ok && p == p2), nil
+		},
+		ProtocolsTag(): List{[]value.Value{protocolEq, protocolStringer}},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestRegisterType(t *testing.T) {
+	env, _ := builtinEnv.insert("p", testPoint{1, 2})
+	env, _ = env.insert("p2", testPoint{3, 4})
+	cases := []struct {
+		name string
+		src  string
+		want value.Value
+	}{
+		{"eq", "p == p", trueValue},
+		{"not eq", "p == p2", falseValue},
+		{"protocol", "implements p protocolStringer", trueValue},
+		{"match", "match p [(pin p) { true } '_ { false }]", trueValue},
+	}
+	for _, c := range cases {
+		src := "'got = (" + c.src + ")"
+		b, err := parser.Parse(parser.NewLexer("test.qn", bufio.NewReader(strings.NewReader(src))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Run(env, b)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if v, _ := got.get("got"); v != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, v, c.want)
+		}
+	}
+
+	if s := valueString(newThread(defaultInstance, Options{}), testPoint{}); s != "point" {
+		t.Errorf("stringer: got %q", s)
+	}
+}
+
+func TestRegisterTypeErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		tag   value.Tag
+		attrs map[value.Tag]interface{}
+		err   string
+	}{
+		{"already registered", tagTestPoint, nil, "type is already registered"},
+		{
+			"invalid attribute",
+			value.NewTag(),
+			map[value.Tag]interface{}{StringerTag(): func() {}},
+			"invalid attribute",
+		},
+		{
+			"missing protocol attribute",
+			value.NewTag(),
+			map[value.Tag]interface{}{ProtocolsTag(): protocolStringer},
+			"type doesn't implement stringer: missing stringer",
+		},
+	}
+	for _, c := range cases {
+		err := RegisterType(c.tag, c.attrs)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error containing %q, got %v", c.name, c.err, err)
+		}
+	}
+}
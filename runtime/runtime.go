@@ -1,10 +1,12 @@
 package runtime
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/erikfastermann/quinn/number"
 	"github.com/erikfastermann/quinn/parser"
@@ -18,77 +20,109 @@ const internal = "internal error"
 type PositionedError struct {
 	Path         string
 	Line, Column int
+	Name         string // name of the enclosing block, empty at the top level
 	err          error
+	sources      *sources
 }
 
+// maxCollapsedFrames is the longest sequence of frames which is
+// only printed once if it is repeated, e.g. in a recursive call.
+const maxCollapsedFrames = 16
+
 func (e PositionedError) Error() string {
-	var b strings.Builder
-	cur := e
+	frames := make([]PositionedError, 0)
+	var cause error = e
 	for {
-		b.WriteString(cur.Path)
-		b.WriteString("|")
-		b.WriteString(strconv.Itoa(cur.Line))
-		b.WriteString(" col ")
-		b.WriteString(strconv.Itoa(cur.Column))
-
-		b.WriteString("\n\t")
-		if line, err := getLine(cur.Path, cur.Line); err == nil {
-			b.WriteString(strings.TrimSpace(line))
-		} else {
-			b.WriteString("failed getting line info: ")
-			b.WriteString(err.Error())
+		cur, ok := cause.(PositionedError)
+		if !ok {
+			break
 		}
+		frames = append(frames, cur)
+		cause = cur.err
+	}
 
-		b.WriteString("\n\n")
-		if next, ok := cur.err.(PositionedError); ok {
-			cur = next
-		} else {
-			b.WriteString(cur.err.Error())
-			break
+	var b strings.Builder
+	b.WriteString("Traceback (most recent call last):\n\n")
+	for i := 0; i < len(frames); {
+		size, repeated := repeatedFrames(frames[i:])
+		for _, f := range frames[i : i+size] {
+			f.writeFrame(&b)
 		}
+		if repeated > 1 {
+			fmt.Fprintf(
+				&b,
+				"[previous %d frame(s) repeated %d more time(s)]\n\n",
+				size,
+				repeated-1,
+			)
+		}
+		i += size * repeated
 	}
+	b.WriteString(cause.Error())
 	return b.String()
 }
 
-func (e PositionedError) Unwrap() error {
-	return e.err
+func (e PositionedError) writeFrame(b *strings.Builder) {
+	b.WriteString(e.Path)
+	b.WriteString("|")
+	b.WriteString(strconv.Itoa(e.Line))
+	b.WriteString(" col ")
+	b.WriteString(strconv.Itoa(e.Column))
+	if e.Name != "" {
+		b.WriteString(" in ")
+		b.WriteString(e.Name)
+	}
+
+	b.WriteString("\n\t")
+	if line, err := e.sources.line(e.Path, e.Line); err == nil {
+		b.WriteString(strings.TrimSpace(line))
+	} else {
+		b.WriteString("failed getting line info: ")
+		b.WriteString(err.Error())
+	}
+	b.WriteString("\n\n")
 }
 
-var (
-	lineInfoMutex sync.Mutex
-	lineInfo      = make(map[string][]string)
-)
+func (e PositionedError) sameFrame(e2 PositionedError) bool {
+	return e.Path == e2.Path &&
+		e.Line == e2.Line &&
+		e.Column == e2.Column &&
+		e.Name == e2.Name
+}
 
-func RegisterLineInfo(path string, lines []string) error {
-	lineInfoMutex.Lock()
-	defer lineInfoMutex.Unlock()
-	if _, ok := lineInfo[path]; ok {
-		return fmt.Errorf("duplicate path %q", path)
+// repeatedFrames finds the sequence at the start of frames which covers
+// the most frames when repeated back to back.
+func repeatedFrames(frames []PositionedError) (size, repeated int) {
+	size, repeated = 1, 1
+	for n := 1; n <= maxCollapsedFrames && 2*n <= len(frames); n++ {
+		r := 1
+	outer:
+		for (r+1)*n <= len(frames) {
+			for i := 0; i < n; i++ {
+				if !frames[i].sameFrame(frames[r*n+i]) {
+					break outer
+				}
+			}
+			r++
+		}
+		if r > 1 && r*n > size*repeated {
+			size, repeated = n, r
+		}
 	}
-	lineInfo[path] = lines
-	return nil
+	return size, repeated
 }
 
-func getLine(path string, line int) (string, error) {
-	line--
-	lineInfoMutex.Lock()
-	defer lineInfoMutex.Unlock()
-	lines, ok := lineInfo[path]
-	if !ok {
-		return "", fmt.Errorf("unknown path %s", path)
-	}
-	if line < 0 || line >= len(lines) {
-		return "", fmt.Errorf(
-			"line index out of bounds (%d with length %d)",
-			line,
-			len(lines),
-		)
-	}
-	return lines[line], nil
+func (e PositionedError) Unwrap() error {
+	return e.err
+}
+
+// RegisterLineInfo registers the lines of the code at path,
+// which are shown in the tracebacks of Run.
+func RegisterLineInfo(path string, lines []string) error {
+	return defaultInstance.sources.register(path, lines)
 }
 
 var (
-	tagReturner = value.NewTag()
 	tagEq       = value.NewTag()
 	tagStringer = value.NewTag()
 	tagMatcher  = value.NewTag()
@@ -118,14 +152,15 @@ func newTagMatcher(tagFuncPairs ...interface{}) func(value.Value, value.Tag) (va
 	}
 }
 
-func matcherEq(matcher, v value.Value) (value.Value, error) {
-	bV, err := eq(matcher, v)
+func matcherEq(t *thread, matcher, v value.Value) (value.Value, error) {
+	bV, err := eq(t, matcher, v)
 	if err != nil {
 		return nil, err
 	}
 	return List{[]value.Value{bV, List{}}}, nil
 }
 
+// tagValues holds the attributes of the builtin types.
 var tagValues map[value.Tag]func(value.Value, value.Tag) (v value.Value, ok bool)
 
 func init() {
@@ -161,29 +196,88 @@ func init() {
 			tagStringer, stringerList,
 			tagMatcher, matcherList,
 		),
+		tagMap: newTagMatcher(
+			tagEq, eqMap,
+			tagStringer, stringerMap,
+			tagMatcher, matcherMap,
+		),
+		tagSet: newTagMatcher(
+			tagEq, eqSet,
+			tagStringer, stringerSet,
+			tagMatcher, matcherEq,
+		),
+		tagError: newTagMatcher(
+			tagEq, eqError,
+			tagStringer, stringerError,
+			tagMatcher, matcherError,
+		),
 		tagMut: newTagMatcher(
 			tagEq, eqMut,
 			tagStringer, stringerMut,
 			tagMatcher, matcherEq,
 		),
 		tagBlock: newTagMatcher(tagStringer, stringerBlock),
+		tagOrPattern: newTagMatcher(
+			tagStringer, stringerOrPattern,
+			tagMatcher, matcherOrPattern,
+		),
+		tagGuardPattern: newTagMatcher(
+			tagStringer, stringerGuardPattern,
+			tagMatcher, matcherGuardPattern,
+		),
+		tagPinPattern: newTagMatcher(
+			tagStringer, stringerPinPattern,
+			tagMatcher, matcherPinPattern,
+		),
+		tagRestParam:  newTagMatcher(tagStringer, stringerRestParam),
+		tagKeywordArg: newTagMatcher(tagStringer, stringerKeywordArg),
 		tagTag: newTagMatcher(
 			tagEq, eqTag,
 			tagStringer, stringerTag,
 			tagMatcher, matcherEq,
 		),
+		tagProtocol: newTagMatcher(
+			tagEq, eqProtocol,
+			tagStringer, stringerProtocol,
+			tagMatcher, matcherEq,
+		),
+		tagEnvironment: newTagMatcher(
+			tagEq, eqEnvironment,
+			tagStringer, stringerEnvironment,
+			tagMatcher, matcherEq,
+		),
+		tagStop: newTagMatcher(
+			tagEq, eqStop,
+			tagStringer, stringerStop,
+			tagMatcher, matcherEq,
+		),
+		tagTask: newTagMatcher(
+			tagEq, eqTask,
+			tagStringer, stringerTask,
+			tagMatcher, matcherEq,
+		),
+		tagChan: newTagMatcher(
+			tagEq, eqChan,
+			tagStringer, stringerChan,
+			tagMatcher, matcherEq,
+		),
+		tagSelectCase: newTagMatcher(
+			tagEq, eqSelectCase,
+			tagStringer, stringerSelectCase,
+			tagMatcher, matcherEq,
+		),
 		tagOpaque: opaqueMatcher,
 	}
 }
 
-func valueString(v value.Value) string {
+func valueString(t *thread, v value.Value) string {
 	// should we also recover panics?
 	// should we try to string until cycle?
 
 	if v == nil {
 		return "<unknown (value is nil)>"
 	}
-	attrs, ok := tagValues[v.Tag()]
+	attrs, ok := t.inst.types.get(v.Tag())
 	if !ok {
 		return fmt.Sprintf("<%T (error: tag not found)>", v)
 	}
@@ -199,7 +293,7 @@ func valueString(v value.Value) string {
 			stringer,
 		)
 	}
-	sV, err := b.runWithoutEnv(v)
+	sV, err := b.runWithoutEnv(t, v)
 	if err != nil {
 		return fmt.Sprintf("<%T (stringer error: %v)", v, err)
 	}
@@ -214,46 +308,87 @@ func valueString(v value.Value) string {
 	return string(s)
 }
 
-func getAttribute(v value.Value, tag value.Tag) (value.Value, error) {
-	attrs, ok := tagValues[v.Tag()]
+func getAttribute(t *thread, v value.Value, tag value.Tag) (value.Value, error) {
+	attrs, ok := t.inst.types.get(v.Tag())
 	if !ok {
-		return nil, fmt.Errorf("%s: value tag not found", valueString(v))
+		return nil, fmt.Errorf("%s: value tag not found", valueString(t, v))
 	}
 	attr, ok := attrs(v, tag)
 	if !ok {
-		return nil, fmt.Errorf("%s: attribute tag not found", valueString(v))
+		return nil, fmt.Errorf("%s: attribute tag not found", valueString(t, v))
 	}
 	return attr, nil
 }
 
-func getAttributeBlock(v value.Value, tag value.Tag) (Block, error) {
-	attr, err := getAttribute(v, tag)
+func getAttributeBlock(t *thread, v value.Value, tag value.Tag) (Block, error) {
+	attr, err := getAttribute(t, v, tag)
 	if err != nil {
 		return nil, err
 	}
 	b, ok := attr.(Block)
 	if !ok {
-		return nil, fmt.Errorf("%s: attribute is not a Block", valueString(v))
+		return nil, fmt.Errorf("%s: attribute is not a Block", valueString(t, v))
 	}
 	return b, nil
 }
 
 // TODO: should eq return Bool?
-func eq(x, y value.Value) (value.Value, error) {
-	b, err := getAttributeBlock(x, tagEq)
+func eq(t *thread, x, y value.Value) (value.Value, error) {
+	b, err := getAttributeBlock(t, x, tagEq)
 	if err != nil {
 		return nil, err
 	}
-	return b.runWithoutEnv(x, y)
+	return b.runWithoutEnv(t, x, y)
 }
 
-func Run(env *Environment, block parser.Block) (*Environment, error) {
-	if env == nil {
-		env = builtinEnv
-	}
-	env, _, err := runCode(env, block)
+func isEqual(t *thread, x, y value.Value) (bool, error) {
+	bV, err := eq(t, x, y)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	b, ok := bV.(Bool)
+	if !ok {
+		return false, fmt.Errorf("equal: expected bool, got %s", valueString(t, bV))
 	}
-	return env, nil
+	return b.AsBool(), nil
+}
+
+// DefaultMaxCallDepth is used if no other maximum call depth is configured.
+const DefaultMaxCallDepth = 10000
+
+type Options struct {
+	// MaxCallDepth is the maximum number of nested block calls.
+	// Calls in tail position don't count.
+	// If zero, DefaultMaxCallDepth is used.
+	MaxCallDepth int
+
+	// MaxSteps is the maximum number of evaluated elements and entered blocks.
+	// If zero, there is no limit.
+	MaxSteps int
+
+	// MaxAllocs is the maximum number of list, map and set elements allocated.
+	// If zero, there is no limit.
+	MaxAllocs int
+
+	// Timeout is the maximum wall-clock time of a run.
+	// If zero, there is no limit.
+	Timeout time.Duration
+
+	// Stdin, Stdout and Stderr are used by the I/O builtins,
+	// if nil os.Stdin, os.Stdout and os.Stderr are used.
+	// Output to Stdout is buffered and flushed at the end of a run.
+	Stdin          io.Reader
+	Stdout, Stderr io.Writer
+
+	// Warnings receives the warnings of the static checker
+	// for code evaluated by an Interpreter, if not nil.
+	Warnings io.Writer
+}
+
+func Run(env *Environment, block parser.Block) (*Environment, error) {
+	return RunWithOptions(env, block, Options{})
+}
+
+func RunWithOptions(env *Environment, block parser.Block, opts Options) (*Environment, error) {
+	return RunContextWithOptions(context.Background(), env, block, opts)
 }
@@ -0,0 +1,52 @@
+package runtime
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/erikfastermann/quinn/parser"
+)
+
+// evalCase runs src, which binds the result to got.
+// want is the code of the expected value, if err is set
+// src has to fail with an error containing it instead.
+type evalCase struct {
+	name string
+	src  string
+	want string
+	err  string
+}
+
+func runCases(t *testing.T, cases []evalCase) {
+	t.Helper()
+	for _, c := range cases {
+		src := c.src
+		if c.err == "" {
+			src += "\n'testEqual = (got == (" + c.want + "))"
+		}
+		env, err := runSource(src)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: expected error containing %q, got %v", c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if v, _ := env.get("testEqual"); v != trueValue {
+			got, _ := env.get("got")
+			t.Errorf("%s: got %v, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func runSource(src string) (*Environment, error) {
+	b, err := parser.Parse(parser.NewLexer("test.qn", bufio.NewReader(strings.NewReader(src))))
+	if err != nil {
+		return nil, err
+	}
+	return Run(nil, b)
+}
@@ -0,0 +1,142 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/erikfastermann/quinn/value"
+)
+
+var tagSet = value.NewTag()
+
+type Set struct {
+	h hamt
+}
+
+func (Set) Tag() value.Tag {
+	return tagSet
+}
+
+func newSet(t *thread, values []value.Value) (Set, error) {
+	var s Set
+	for _, v := range values {
+		var err error
+		if s, err = s.add(t, v); err != nil {
+			return Set{}, err
+		}
+	}
+	return s, nil
+}
+
+func (s Set) has(t *thread, v value.Value) (bool, error) {
+	_, ok, err := s.h.get(t, v)
+	return ok, err
+}
+
+func (s Set) add(t *thread, v value.Value) (Set, error) {
+	if ok, err := s.has(t, v); err != nil || ok {
+		return s, err
+	}
+	h, err := s.h.insert(t, v, unit)
+	if err != nil {
+		return Set{}, err
+	}
+	return Set{h}, nil
+}
+
+func (s Set) remove(t *thread, v value.Value) (Set, error) {
+	h, err := s.h.delete(t, v)
+	if err != nil {
+		return Set{}, err
+	}
+	return Set{h}, nil
+}
+
+func (s Set) elements() []value.Value {
+	entries := s.h.entries()
+	elements := make([]value.Value, len(entries))
+	for i, e := range entries {
+		elements[i] = e.key
+	}
+	return elements
+}
+
+// union, intersection and difference count each element
+// of their result against the allocation limit.
+func (s Set) union(t *thread, s2 Set) (Set, error) {
+	out := s
+	if err := t.limits.alloc(s.h.len); err != nil {
+		return Set{}, err
+	}
+	for _, v := range s2.elements() {
+		ok, err := out.has(t, v)
+		if err != nil {
+			return Set{}, err
+		}
+		if ok {
+			continue
+		}
+		if err := t.limits.alloc(1); err != nil {
+			return Set{}, err
+		}
+		if out, err = out.add(t, v); err != nil {
+			return Set{}, err
+		}
+	}
+	return out, nil
+}
+
+func (s Set) intersection(t *thread, s2 Set) (Set, error) {
+	return s.filter(t, s2, true)
+}
+
+func (s Set) difference(t *thread, s2 Set) (Set, error) {
+	return s.filter(t, s2, false)
+}
+
+func (s Set) filter(t *thread, s2 Set, keep bool) (Set, error) {
+	var out Set
+	for _, v := range s.elements() {
+		ok, err := s2.has(t, v)
+		if err != nil {
+			return Set{}, err
+		}
+		if ok == keep {
+			if err := t.limits.alloc(1); err != nil {
+				return Set{}, err
+			}
+			if out, err = out.add(t, v); err != nil {
+				return Set{}, err
+			}
+		}
+	}
+	return out, nil
+}
+
+func (s Set) subset(t *thread, s2 Set) (bool, error) {
+	if s.h.len > s2.h.len {
+		return false, nil
+	}
+	for _, v := range s.elements() {
+		ok, err := s2.has(t, v)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func eqSet(t *thread, s Set, v value.Value) (value.Value, error) {
+	s2, ok := v.(Set)
+	if !ok || s.h.len != s2.h.len {
+		return falseValue, nil
+	}
+	ok, err := s.subset(t, s2)
+	if err != nil {
+		return nil, err
+	}
+	return NewBool(ok), nil
+}
+
+func stringerSet(t *thread, s Set) (value.Value, error) {
+	return String(fmt.Sprintf("(set %s)", valueString(t, List{s.elements()}))), nil
+}
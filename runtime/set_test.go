@@ -0,0 +1,25 @@
+package runtime
+
+import "testing"
+
+func TestSet(t *testing.T) {
+	runCases(t, []evalCase{
+		{name: "duplicates", src: "'got = (len (set [1 2 2 3 1]))", want: "3"},
+		{name: "elements in insertion order", src: "'got = (elements (set [3 1 2 1]))", want: "[3 1 2]"},
+		{name: "equality ignores order", src: "'got = ((set [1 2 3]) == (set [3 2 1]))", want: "true"},
+		{name: "different sets", src: "'got = ((set [1 2]) == (set [1 2 3]))", want: "false"},
+		{name: "has", src: "'got = [(has (set [1 \"a\"]) \"a\") (has (set [1]) 2)]", want: "[true false]"},
+		{name: "add", src: "'got = (add (set [1]) 2)", want: "set [1 2]"},
+		{name: "add existing", src: "'got = (add (set [1 2]) 1)", want: "set [1 2]"},
+		{name: "remove", src: "'got = (remove (set [1 2]) 1)", want: "set [2]"},
+		{name: "remove missing", src: "'got = (remove (set [1 2]) 3)", want: "set [1 2]"},
+		{name: "union", src: "'got = (union (set [1 2]) (set [2 3]))", want: "set [1 2 3]"},
+		{name: "intersection", src: "'got = (intersection (set [1 2 3]) (set [2 3 4]))", want: "set [2 3]"},
+		{name: "difference", src: "'got = (difference (set [1 2 3]) (set [2 4]))", want: "set [1 3]"},
+		{name: "subset", src: "'got = [(subset (set [1]) (set [1 2])) (subset (set [1 3]) (set [1 2]))]", want: "[true false]"},
+		{name: "empty", src: "'got = (len (set []))", want: "0"},
+		{name: "list elements", src: "'got = (has (set [[1 2]]) [1 2])", want: "true"},
+		{name: "unhashable element", src: "'got = (set [(set [])])", err: "can't be used as a key"},
+		{name: "union with list", src: "'got = (union (set []) [1])", err: "Set"},
+	})
+}
@@ -0,0 +1,106 @@
+package runtime
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/erikfastermann/quinn/value"
+)
+
+// errConflict restarts a transaction, it can't be caught by try or default.
+var errConflict = errors.New("transaction conflict")
+
+// transaction buffers the writes to Muts in an atomically block
+// and records the versions of the Muts read.
+type transaction struct {
+	reads  map[*Mut]uint64
+	writes map[*Mut]value.Value
+}
+
+func newTransaction() *transaction {
+	return &transaction{
+		reads:  make(map[*Mut]uint64),
+		writes: make(map[*Mut]value.Value),
+	}
+}
+
+// load returns errConflict if the Muts read so far
+// are no longer a consistent snapshot.
+func (tx *transaction) load(m *Mut) (value.Value, error) {
+	if v, ok := tx.writes[m]; ok {
+		return v, nil
+	}
+	v, version := m.snapshot()
+	if seen, ok := tx.reads[m]; ok && seen != version {
+		return nil, errConflict
+	}
+	tx.reads[m] = version
+	for read, seen := range tx.reads {
+		if _, version := read.snapshot(); version != seen {
+			return nil, errConflict
+		}
+	}
+	return v, nil
+}
+
+func (tx *transaction) store(m *Mut, v value.Value) {
+	tx.writes[m] = v
+}
+
+// commit applies the writes if none of the Muts read changed.
+func (tx *transaction) commit() bool {
+	muts := make([]*Mut, 0, len(tx.reads)+len(tx.writes))
+	for m := range tx.reads {
+		muts = append(muts, m)
+	}
+	for m := range tx.writes {
+		if _, ok := tx.reads[m]; !ok {
+			muts = append(muts, m)
+		}
+	}
+	sort.Slice(muts, func(i, j int) bool {
+		return muts[i].id < muts[j].id
+	})
+	for _, m := range muts {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+	}
+
+	for m, seen := range tx.reads {
+		if m.version != seen {
+			return false
+		}
+	}
+	for m, v := range tx.writes {
+		m.v = v
+		m.version++
+	}
+	return true
+}
+
+// atomically runs b as a transaction: the Muts used in b
+// are read and written as if no other task ran at the same time.
+// On a conflict with another task b runs again,
+// so b shouldn't have other side effects. Leaving b
+// with return, break or continue commits the transaction.
+// Nested atomically blocks are part of the outer transaction.
+func atomically(t *thread, b Block) (value.Value, error) {
+	if t.tx != nil {
+		return b.runWithoutEnv(t)
+	}
+	for {
+		t.tx = newTransaction()
+		v, err := b.runWithoutEnv(t)
+		tx := t.tx
+		t.tx = nil
+		if errors.Is(err, errConflict) {
+			continue
+		}
+		if err != nil && !isSignal(err) {
+			return nil, err
+		}
+		if tx.commit() {
+			return v, err
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/erikfastermann/quinn/number"
+	"github.com/erikfastermann/quinn/value"
+)
+
+func TestTransactionConflict(t *testing.T) {
+	a, b := newMut(number.FromInt(1)), newMut(number.FromInt(2))
+
+	tx := newTransaction()
+	if _, err := tx.load(a); err != nil {
+		t.Fatal(err)
+	}
+	tx.store(b, number.FromInt(3))
+	a.store(number.FromInt(10))
+	if tx.commit() {
+		t.Fatal("commit succeeded after a read Mut changed")
+	}
+	if v := b.load(); v.(number.Number).Cmp(number.FromInt(2)) != 0 {
+		t.Fatalf("failed commit wrote %v", v)
+	}
+
+	tx = newTransaction()
+	if _, err := tx.load(a); err != nil {
+		t.Fatal(err)
+	}
+	a.store(number.FromInt(11))
+	if _, err := tx.load(b); err != errConflict {
+		t.Fatalf("expected conflict loading from an inconsistent snapshot, got %v", err)
+	}
+
+	tx = newTransaction()
+	tx.store(a, number.FromInt(5))
+	if v, err := tx.load(a); err != nil || v.(number.Number).Cmp(number.FromInt(5)) != 0 {
+		t.Fatalf("transaction doesn't see its own write: %v, %v", v, err)
+	}
+	if v := a.load(); v.(number.Number).Cmp(number.FromInt(11)) != 0 {
+		t.Fatalf("write visible before commit: %v", v)
+	}
+	if !tx.commit() {
+		t.Fatal("commit failed without conflicts")
+	}
+	if v := a.load(); v.(number.Number).Cmp(number.FromInt(5)) != 0 {
+		t.Fatalf("commit didn't write: %v", v)
+	}
+}
+
+func TestAtomicallyRetries(t *testing.T) {
+	in := NewInterpreter(Options{})
+	runs := 0
+	err := in.Define("interfere", func(m *Mut) (value.Value, error) {
+		runs++
+		if runs == 1 {
+			m.store(number.FromInt(100))
+		}
+		return unit, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := mustEval(t, in, `'a = mut 0
+atomically {
+	'x = load a
+	interfere a
+	a <- (x + 1)
+}
+load a`)
+	if runs != 2 {
+		t.Fatalf("block ran %d time(s), want 2", runs)
+	}
+	if v.(number.Number).Cmp(number.FromInt(101)) != 0 {
+		t.Fatalf("got %v, want 101", v)
+	}
+}
+
+func TestAtomicallyConcurrent(t *testing.T) {
+	in := NewInterpreter(Options{})
+	v := mustEval(t, in, `'a = mut 1000
+'b = mut 0
+'mover = def [] {
+	spawn {
+		'i = mut 0
+		loop {
+			if ((load i) == 100) {
+				break ()
+			}
+			atomically {
+				a <- ((load a) - 1)
+				b <- ((load b) + 1)
+			}
+			i <- ((load i) + 1)
+		}
+	}
+}
+'movers = [(mover ()) (mover ()) (mover ()) (mover ())]
+await (movers@0)
+await (movers@1)
+await (movers@2)
+await (movers@3)
+[(load a) (load b)]`)
+	l := v.(List)
+	if l.data[0].(number.Number).Cmp(number.FromInt(600)) != 0 ||
+		l.data[1].(number.Number).Cmp(number.FromInt(400)) != 0 {
+		t.Fatalf("got %v", l.data)
+	}
+}
+
+func TestAtomicallySignals(t *testing.T) {
+	runCases(t, []evalCase{
+		{
+			name: "return",
+			src:  "'a = mut 0\n'f = def [] { atomically { a <- 1\nreturn 2 } }\n'got = [(f ()) (load a)]",
+			want: "[2 1]",
+		},
+		{
+			name: "break",
+			src:  "'a = mut 0\nloop { atomically { a <- ((load a) + 1)\nbreak () } }\n'got = (load a)",
+			want: "1",
+		},
+		{
+			name: "continue",
+			src: `'a = mut 0
+'i = mut 0
+loop {
+	if ((load i) == 3) { break () }
+	i <- ((load i) + 1)
+	atomically { a <- ((load a) + 1)
+continue () }
+}
+'got = (load a)`,
+			want: "3",
+		},
+		{name: "error", src: "'a = mut 0\ntry { atomically { a <- 1\nraise 1 } } catch (['e 's] -> { () })\n'got = (load a)", want: "0"},
+	})
+}
@@ -10,10 +10,18 @@ var tagString = value.NewTag()
 
 type String string
 
+func NewString(s string) String {
+	return String(s)
+}
+
 func (String) Tag() value.Tag {
 	return tagString
 }
 
+func (s String) AsString() string {
+	return string(s)
+}
+
 func eqString(s String, v value.Value) (value.Value, error) {
 	s2, ok := v.(String)
 	return NewBool(ok && s == s2), nil
@@ -0,0 +1,187 @@
+package runtime
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// thread holds the state of a single flow of execution.
+type thread struct {
+	// names of the named blocks currently running, innermost last
+	names []string
+	// fn is the function activation of the running code, nil at the top level
+	fn *activation
+
+	depth, maxDepth int
+	limits          *limits
+	streams         *streams
+
+	ctx context.Context
+	// done is the done channel of ctx, nil if ctx can't be canceled
+	done <-chan struct{}
+	// cancel releases the deadline of ctx, nil if t doesn't own one
+	cancel context.CancelFunc
+	// callThread creates the thread for each call of a func decoded by t,
+	// it is nil if t runs code and the calls run as part of that run
+	callThread func() *thread
+
+	// gen is the generator whose body runs in the thread
+	gen *generator
+
+	// handlers are the handlers installed by the innermost handle
+	handlers *handlerFrame
+
+	// tx is the transaction of the running atomically block
+	tx *transaction
+
+	run  *run
+	inst *instance
+}
+
+// run holds the state shared by all threads of a run.
+type run struct {
+	mu sync.Mutex
+	// gens are the generators started in the run which are still suspended
+	gens map[*generator]bool
+}
+
+func (r *run) addGenerator(g *generator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.gens == nil {
+		r.gens = make(map[*generator]bool)
+	}
+	r.gens[g] = true
+}
+
+func (r *run) removeGenerator(g *generator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.gens, g)
+}
+
+// closeGenerators stops the generators of the run and waits until
+// their bodies are unwound.
+func (r *run) closeGenerators() {
+	r.mu.Lock()
+	gens := r.gens
+	r.gens = nil
+	r.mu.Unlock()
+	for g := range gens {
+		g.stop()
+	}
+}
+
+func newThread(inst *instance, opts Options) *thread {
+	maxDepth := opts.MaxCallDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxCallDepth
+	}
+	t := &thread{
+		maxDepth: maxDepth,
+		limits:   newLimits(opts),
+		streams:  newStreams(opts),
+		run:      &run{},
+		inst:     inst,
+	}
+	t.setContext(context.Background())
+	return t
+}
+
+// spawn returns a thread for a new goroutine,
+// which shares the limits, streams, context and run of t.
+func (t *thread) spawn() *thread {
+	return &thread{
+		maxDepth: t.maxDepth,
+		limits:   t.limits,
+		streams:  t.streams,
+		ctx:      t.ctx,
+		done:     t.done,
+		run:      t.run,
+		inst:     t.inst,
+	}
+}
+
+// fork returns a thread for a goroutine continuing the computation
+// of t while t waits for it. It shares the transaction, generator,
+// function activation and handlers of t and starts at its call depth.
+func (t *thread) fork() *thread {
+	child := t.spawn()
+	child.names = append([]string(nil), t.names...)
+	child.fn = t.fn
+	child.depth = t.depth
+	child.gen = t.gen
+	child.handlers = t.handlers
+	child.tx = t.tx
+	return child
+}
+
+// setContext sets the context of t, which is done
+// at the latest when the deadline of the limits is reached.
+func (t *thread) setContext(ctx context.Context) {
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+	if !t.limits.deadline.IsZero() {
+		ctx, t.cancel = context.WithDeadline(ctx, t.limits.deadline)
+	}
+	t.ctx, t.done = ctx, ctx.Done()
+}
+
+// finish ends the run started with t: generators which are still
+// suspended are closed, stdout is flushed and the context is released.
+func (t *thread) finish() error {
+	t.run.closeGenerators()
+	err := t.streams.flush()
+	if t.cancel != nil {
+		t.cancel()
+	}
+	return err
+}
+
+// checkContext returns an error if the context of t is done.
+func (t *thread) checkContext() error {
+	select {
+	case <-t.done:
+		return t.ctxErr()
+	default:
+		return nil
+	}
+}
+
+// ctxErr returns the error for the done context of t.
+func (t *thread) ctxErr() error {
+	if !t.limits.deadline.IsZero() && !time.Now().Before(t.limits.deadline) {
+		return &LimitError{Limit: LimitDeadline}
+	}
+	return canceled{t.ctx.Err()}
+}
+
+func (t *thread) enterCall() error {
+	if t.depth >= t.maxDepth {
+		return &LimitError{Limit: LimitCallDepth, Max: int64(t.maxDepth)}
+	}
+	t.depth++
+	return nil
+}
+
+func (t *thread) exitCall() {
+	t.depth--
+}
+
+func (t *thread) push(name string) {
+	t.names = append(t.names, name)
+}
+
+func (t *thread) pop() {
+	t.names = t.names[:len(t.names)-1]
+}
+
+func (t *thread) name() string {
+	if len(t.names) == 0 {
+		return ""
+	}
+	return t.names[len(t.names)-1]
+}
@@ -10,6 +10,10 @@ type Unit struct{}
 
 var unit value.Value = Unit{}
 
+func NewUnit() Unit {
+	return Unit{}
+}
+
 func (Unit) Tag() value.Tag {
 	return tagUnit
 }
@@ -22,6 +22,10 @@ func (t Tag) Valid() bool {
 	return t.id != 0
 }
 
+func (t Tag) Hash() uint64 {
+	return uint64(t.id)
+}
+
 var tagTag = NewTag()
 
 func (t Tag) Tag() Tag {